@@ -0,0 +1,54 @@
+package heatshrink
+
+import (
+	"bytes"
+	"sync"
+)
+
+/* Encoders kept for reuse by AppendCompress, for each window and
+* lookahead size. AppendDecompress doesn't need a decoder: see fast.go. */
+var encoder_pool [HEATSHRINK_MAX_WINDOW_BITS + 1][HEATSHRINK_MAX_WINDOW_BITS]sync.Pool
+
+// AppendCompress appends the compressed form of src to dst and returns
+// the extended buffer, like the strconv.Append functions. Internal buffers
+// are reused across calls, so with a dst of sufficient capacity (and no
+// options) it doesn't allocate. A filter option makes it allocate a
+// filtered copy of src.
+func AppendCompress(dst, src []byte, window, lookahead uint8, opts ...Option) []byte {
+	o := get_options(opts)
+	flags, ok := filter_flags(o)
+	if !valid_params(window, lookahead) || !ok {
+		return dst
+	}
+	hse := get_encoder(window, lookahead)
+	encoder_options(hse, opts) /* pooled encoders may have others */
+	encoder_preset(hse, preset_dict(o, window, nil))
+	hse.outbuf = *bytes.NewBuffer(dst)
+	out := compress(hse, filter_encode(flags, src))
+	hse.outbuf = bytes.Buffer{} /* don't hold on to the caller's memory */
+	encoder_pool[window][lookahead].Put(hse)
+	return out
+}
+
+// AppendDecompress appends the decompressed form of src to dst and
+// returns the extended buffer, see AppendCompress.
+func AppendDecompress(dst, src []byte, window, lookahead uint8, opts ...Option) []byte {
+	o := get_options(opts)
+	flags, ok := filter_flags(o)
+	if !valid_params(window, lookahead) || !ok {
+		return dst
+	}
+	out, _, _ := decode_fast(window, lookahead, preset_dict(o, window, nil), src, dst, -1)
+	filter_decode(flags, out[len(dst):])
+	return out
+}
+
+func get_encoder(window_sz2, lookahead_sz2 uint8) *encoder {
+	if window_sz2 <= HEATSHRINK_MAX_WINDOW_BITS && lookahead_sz2 < window_sz2 {
+		if hse, ok := encoder_pool[window_sz2][lookahead_sz2].Get().(*encoder); ok {
+			encoder_reset(hse)
+			return hse
+		}
+	}
+	return encoder_alloc(window_sz2, lookahead_sz2)
+}
@@ -0,0 +1,52 @@
+package heatshrink
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+/* With a dst of sufficient capacity, and no options, the Append
+* functions don't allocate (but for the debug logs). */
+func TestAppendAllocs(t *testing.T) {
+	if heatshrink_debugging_logs {
+		t.Skip("debug logs allocate")
+	}
+	data := bytes.Repeat([]byte("hello, heatshrink! "), 100)
+	comp := make([]byte, 0, 2*len(data))
+	out := make([]byte, 0, len(data))
+	if n := testing.AllocsPerRun(100, func() {
+		comp = AppendCompress(comp[:0], data, 8, 4)
+	}); n != 0 {
+		t.Errorf("AppendCompress: %v allocs per run, want 0", n)
+	}
+	if n := testing.AllocsPerRun(100, func() {
+		out = AppendDecompress(out[:0], comp, 8, 4)
+	}); n != 0 {
+		t.Errorf("AppendDecompress: %v allocs per run, want 0", n)
+	}
+	if !bytes.Equal(out, data) {
+		t.Errorf("round trip mismatch")
+	}
+}
+
+/* Filters are applied as by Compress and Decompress, and the filter
+* doesn't touch what was in dst already. */
+func TestAppendFilters(t *testing.T) {
+	data := thumb_corpus(rand.New(rand.NewSource(6)), 5001)
+	prefix := []byte("already here")
+	for _, opt := range []Option{WithThumbFilter(), WithDeltaFilter(2)} {
+		want := Compress(8, 4, data, opt)
+		comp := AppendCompress(prefix, data, 8, 4, opt)
+		if !bytes.Equal(comp[len(prefix):], want) {
+			t.Errorf("%v: AppendCompress differs from Compress", get_options([]Option{opt}))
+		}
+		out := AppendDecompress(prefix, want, 8, 4, opt)
+		if !bytes.Equal(out[:len(prefix)], prefix) || !bytes.Equal(out[len(prefix):], data) {
+			t.Errorf("%v: AppendDecompress mismatch", get_options([]Option{opt}))
+		}
+	}
+	if out := AppendCompress(prefix, data, 8, 4, WithDeltaFilter(0)); len(out) != len(prefix) {
+		t.Errorf("AppendCompress took delta stride 0")
+	}
+}
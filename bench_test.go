@@ -0,0 +1,177 @@
+package heatshrink
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"strings"
+	"sync"
+	"testing"
+)
+
+/* Corpora for the benchmarks, generated (or read) once, 64KiB each. */
+const corpus_size = 64 << 10
+
+var (
+	corpora      map[string][]byte
+	corpora_once sync.Once
+	corpus_names = []string{"text", "firmware", "random", "zeros", "packets"}
+)
+
+func get_corpora() map[string][]byte {
+	corpora_once.Do(func() {
+		r := rand.New(rand.NewSource(1))
+		corpora = map[string][]byte{
+			"text":    bench_text(r),
+			"random":  bench_random(r),
+			"zeros":   make([]byte, corpus_size),
+			"packets": bench_packets(r),
+		}
+		/* The Windows build of the test program, standing in for a
+		* firmware image. */
+		if fw, err := ioutil.ReadFile("test/debug"); err == nil && len(fw) >= corpus_size {
+			corpora["firmware"] = fw[:corpus_size]
+		}
+	})
+	return corpora
+}
+
+/* English-like text: common words, in sentences and paragraphs. */
+func bench_text(r *rand.Rand) []byte {
+	words := strings.Fields(`the of and to a in is it you that he was for on are
+		with as his they be at one have this from or had by hot word but what
+		some we can out other were all there when up use your how said an each
+		she which do their time if will way about many then them write would
+		like so these her long make thing see him two has look more day could
+		go come did number sound no most people my over know water than call
+		first who may down side been now find any new work part take get place`)
+	var sb strings.Builder
+	for sb.Len() < corpus_size {
+		n := 4 + r.Intn(12)
+		for i := 0; i < n; i++ {
+			w := words[r.Intn(len(words))]
+			if i == 0 {
+				w = strings.ToUpper(w[:1]) + w[1:]
+			} else {
+				sb.WriteByte(' ')
+			}
+			sb.WriteString(w)
+		}
+		sb.WriteString(". ")
+		if r.Intn(6) == 0 {
+			sb.WriteString("\n\n")
+		}
+	}
+	return []byte(sb.String()[:corpus_size])
+}
+
+func bench_random(r *rand.Rand) []byte {
+	b := make([]byte, corpus_size)
+	r.Read(b)
+	return b
+}
+
+/* Sensor telemetry: 32 byte packets with a header, a counter, and slowly
+* changing little-endian int16 samples. Benchmarks compress these one
+* packet at a time. */
+const packet_size = 32
+
+func bench_packets(r *rand.Rand) []byte {
+	b := make([]byte, 0, corpus_size)
+	samples := make([]int16, 12)
+	for seq := 0; len(b) < corpus_size; seq++ {
+		b = append(b, 0xa5, 0x5a, byte(seq), byte(seq>>8), 0x01, packet_size)
+		for i := range samples {
+			samples[i] += int16(r.Intn(9) - 4)
+			b = append(b, byte(samples[i]), byte(samples[i]>>8))
+		}
+		b = append(b, 0, 0)
+	}
+	return b[:corpus_size]
+}
+
+/* Run fn for each corpus and window/lookahead combination; chunk is the
+* size of the pieces the corpus is handled in. */
+func bench_all(b *testing.B, fn func(b *testing.B, window, lookahead uint8, data []byte, chunk int)) {
+	c := get_corpora()
+	for _, name := range corpus_names {
+		data, ok := c[name]
+		if !ok { /* only firmware, which comes from a file */
+			b.Run(name, func(b *testing.B) {
+				b.Skipf("no %v corpus: test/debug is missing or under %v bytes", name, corpus_size)
+			})
+			continue
+		}
+		chunk := len(data)
+		if name == "packets" {
+			chunk = packet_size
+		}
+		for w := uint8(HEATSHRINK_MIN_WINDOW_BITS); w <= HEATSHRINK_MAX_WINDOW_BITS; w++ {
+			for l := uint8(HEATSHRINK_MIN_LOOKAHEAD_BITS); l < w; l++ {
+				b.Run(fmt.Sprintf("%v/w%vl%v", name, w, l), func(b *testing.B) {
+					fn(b, w, l, data, chunk)
+				})
+			}
+		}
+	}
+}
+
+func BenchmarkCompress(b *testing.B) {
+	bench_all(b, func(b *testing.B, window, lookahead uint8, data []byte, chunk int) {
+		out := 0
+		b.SetBytes(int64(len(data)))
+		for i := 0; i < b.N; i++ {
+			out = 0
+			for p := 0; p < len(data); p += chunk {
+				out += len(Compress(window, lookahead, data[p:p+chunk]))
+			}
+		}
+		b.ReportMetric(float64(out)/float64(len(data)), "ratio")
+	})
+}
+
+func BenchmarkDecompress(b *testing.B) {
+	bench_all(b, func(b *testing.B, window, lookahead uint8, data []byte, chunk int) {
+		var packed [][]byte
+		out := 0
+		for p := 0; p < len(data); p += chunk {
+			packed = append(packed, Compress(window, lookahead, data[p:p+chunk]))
+			out += len(packed[len(packed)-1])
+		}
+		b.SetBytes(int64(len(data)))
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			for _, c := range packed {
+				Decompress(window, lookahead, c)
+			}
+		}
+		b.ReportMetric(float64(out)/float64(len(data)), "ratio")
+	})
+}
+
+/* The bit writer on its own: literal and backref sized tokens, as
+* est_yield_tag_bit pushes them. */
+func BenchmarkPushBits(b *testing.B) {
+	for _, w := range []uint8{8, 12} {
+		b.Run(fmt.Sprintf("w%vl4", w), func(b *testing.B) {
+			hse := encoder_alloc(w, 4)
+			hse.outbuf.Grow(1 << 20)
+			r := rand.New(rand.NewSource(1))
+			tokens := make([]uint32, 4096)
+			for i := range tokens {
+				tokens[i] = r.Uint32()
+			}
+			b.SetBytes(int64(len(tokens)))
+			for i := 0; i < b.N; i++ {
+				hse.outbuf.Reset()
+				for _, t := range tokens {
+					if t&1 != 0 {
+						push_bits(hse, 9, t)
+					} else {
+						push_bits(hse, 1+w+4, t)
+					}
+				}
+			}
+		})
+	}
+}
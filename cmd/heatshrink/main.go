@@ -0,0 +1,208 @@
+// Command heatshrink compresses, decompresses and inspects heatshrink
+// data from the command line.
+//
+//	heatshrink <command> [-w window] [-l lookahead] [-strict] [-min n]
+//		[-filter arm|thumb|delta] [-stride n] [-fill byte] [file]
+//
+// Input is read from file, or stdin if none is given; output goes to
+// stdout.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+
+	"github.com/whowechina/heatshrink"
+)
+
+type command struct {
+	name  string
+	usage string
+	run   func(window, lookahead uint8, opts []heatshrink.Option, in []byte, out io.Writer) error
+}
+
+var commands = []command{
+	{"compress", "compress input", cmdCompress},
+	{"decompress", "decompress input", cmdDecompress},
+	{"stats", "compress input and report what the output is made of", cmdStats},
+	{"disasm", "list the tokens of compressed input", cmdDisasm},
+	{"bench", "measure speed and ratio on input for all window/lookahead sizes", cmdBench},
+}
+
+func main() {
+	// Turn off debugging logs of the package
+	log.SetOutput(ioutil.Discard)
+
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+/* Run the command in args, returning the exit status. */
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) < 1 {
+		return usage(stderr)
+	}
+	for _, c := range commands {
+		if c.name == args[0] {
+			fs := flag.NewFlagSet(c.name, flag.ContinueOnError)
+			fs.SetOutput(stderr)
+			window := fs.Uint("w", 8, "window size in bits")
+			lookahead := fs.Uint("l", 4, "lookahead size in bits")
+			strict := fs.Bool("strict", false, "use every match smaller than literals (compress, stats, bench)")
+			min := fs.Int("min", 0, "shortest match to use (compress, stats, bench)")
+			filter := fs.String("filter", "", "arm, thumb or delta filter (compress, decompress, stats)")
+			stride := fs.Int("stride", 2, "delta filter stride")
+			fill := fs.Int("fill", 0, "initial window byte (compress, decompress, stats, bench)")
+			if err := fs.Parse(args[1:]); err == flag.ErrHelp {
+				return 0
+			} else if err != nil {
+				return 2
+			}
+
+			var opts []heatshrink.Option
+			if *strict {
+				opts = append(opts, heatshrink.WithStrictBreakEven())
+			}
+			if *min > 0 {
+				opts = append(opts, heatshrink.WithMinMatch(*min))
+			}
+			var err error
+			if *fill < 0 || *fill > 0xff {
+				err = fmt.Errorf("fill must be a byte value")
+			} else if *fill != 0 {
+				opts = append(opts, heatshrink.WithWindowFill(byte(*fill)))
+			}
+			switch *filter {
+			case "":
+			case "arm":
+				opts = append(opts, heatshrink.WithARMFilter())
+			case "thumb":
+				opts = append(opts, heatshrink.WithThumbFilter())
+			case "delta":
+				if *stride < 1 || *stride > heatshrink.FILTER_MAX_STRIDE {
+					err = fmt.Errorf("stride must be 1 to %v", heatshrink.FILTER_MAX_STRIDE)
+				}
+				opts = append(opts, heatshrink.WithDeltaFilter(*stride))
+			default:
+				err = fmt.Errorf("unknown filter %q", *filter)
+			}
+
+			if err == nil {
+				err = checkParams(*window, *lookahead)
+			}
+			var in []byte
+			if err == nil {
+				in, err = readInput(fs.Arg(0), stdin)
+			}
+			if err == nil {
+				err = c.run(uint8(*window), uint8(*lookahead), opts, in, stdout)
+			}
+			if err != nil {
+				fmt.Fprintf(stderr, "heatshrink %v: %v\n", c.name, err)
+				return 1
+			}
+			return 0
+		}
+	}
+	return usage(stderr)
+}
+
+func usage(stderr io.Writer) int {
+	fmt.Fprintf(stderr, "usage: heatshrink <command> [-w window] [-l lookahead] [-strict] [-min n]\n"+
+		"\t[-filter arm|thumb|delta] [-stride n] [-fill byte] [file]\n\ncommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(stderr, "  %-12v %v\n", c.name, c.usage)
+	}
+	return 2
+}
+
+func readInput(name string, stdin io.Reader) ([]byte, error) {
+	if name == "" || name == "-" {
+		return ioutil.ReadAll(stdin)
+	}
+	return ioutil.ReadFile(name)
+}
+
+/* Check the sizes as given, before they are narrowed to uint8. */
+func checkParams(window, lookahead uint) error {
+	if window < heatshrink.HEATSHRINK_MIN_WINDOW_BITS ||
+		window > heatshrink.HEATSHRINK_MAX_WINDOW_BITS ||
+		lookahead < heatshrink.HEATSHRINK_MIN_LOOKAHEAD_BITS ||
+		lookahead >= window {
+		return heatshrink.ErrParams
+	}
+	return nil
+}
+
+func cmdCompress(window, lookahead uint8, opts []heatshrink.Option, in []byte, out io.Writer) error {
+	_, err := out.Write(heatshrink.Compress(window, lookahead, in, opts...))
+	return err
+}
+
+func cmdDecompress(window, lookahead uint8, opts []heatshrink.Option, in []byte, out io.Writer) error {
+	_, err := out.Write(heatshrink.Decompress(window, lookahead, in, opts...))
+	return err
+}
+
+func cmdStats(window, lookahead uint8, opts []heatshrink.Option, in []byte, out io.Writer) error {
+	_, st := heatshrink.CompressWithStats(window, lookahead, in, opts...)
+	_, err := fmt.Fprint(out, st)
+	return err
+}
+
+func cmdDisasm(window, lookahead uint8, opts []heatshrink.Option, in []byte, out io.Writer) error {
+	invalid := 0
+	for _, t := range heatshrink.Disassemble(window, lookahead, in, opts...) {
+		if t.Invalid {
+			invalid++
+		}
+		if _, err := fmt.Fprintln(out, t); err != nil {
+			return err
+		}
+	}
+	if invalid > 0 {
+		return fmt.Errorf("%v invalid tokens", invalid)
+	}
+	return nil
+}
+
+/* Run fn repeatedly for about d, returning the throughput in MB/s. */
+func measure(size int, d time.Duration, fn func()) float64 {
+	n := 0
+	start := time.Now()
+	for time.Since(start) < d || n == 0 {
+		fn()
+		n++
+	}
+	return float64(size) * float64(n) / time.Since(start).Seconds() / 1e6
+}
+
+func cmdBench(_, _ uint8, opts []heatshrink.Option, in []byte, out io.Writer) error {
+	fmt.Fprintf(out, "%6v %9v %7v %13v %15v\n", "window", "lookahead", "ratio", "compress MB/s", "decompress MB/s")
+	for w := uint8(heatshrink.HEATSHRINK_MIN_WINDOW_BITS); w <= heatshrink.HEATSHRINK_MAX_WINDOW_BITS; w++ {
+		for l := uint8(heatshrink.HEATSHRINK_MIN_LOOKAHEAD_BITS); l < w; l++ {
+			var c []byte
+			cspeed := measure(len(in), 200*time.Millisecond, func() {
+				c = heatshrink.AppendCompress(c[:0], in, w, l, opts...)
+			})
+			var d []byte
+			dspeed := measure(len(in), 200*time.Millisecond, func() {
+				d = heatshrink.AppendDecompress(d[:0], c, w, l, opts...)
+			})
+			if !bytes.Equal(d, in) {
+				return fmt.Errorf("window %v, lookahead %v: round trip mismatch", w, l)
+			}
+			_, err := fmt.Fprintf(out, "%6v %9v %7.4f %13.2f %15.2f\n", w, l,
+				float64(len(c))/float64(len(in)), cspeed, dspeed)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+/* Run the command line args on stdin, returning the exit status and
+* what went to stdout and stderr. */
+func runArgs(args string, stdin []byte) (int, []byte, string) {
+	var stdout, stderr bytes.Buffer
+	status := run(strings.Fields(args), bytes.NewReader(stdin), &stdout, &stderr)
+	return status, stdout.Bytes(), stderr.String()
+}
+
+func TestRoundTrip(t *testing.T) {
+	data := []byte(strings.Repeat("heatshrink on the command line, ", 100))
+	file := filepath.Join(t.TempDir(), "data")
+	if err := ioutil.WriteFile(file, data, 0666); err != nil {
+		t.Fatal(err)
+	}
+	for _, flags := range []string{"", "-w 10 -l 5", "-strict -min 3", "-filter delta -stride 4 -fill 32"} {
+		status, comp, stderr := runArgs("compress "+flags+" "+file, nil)
+		if status != 0 || len(comp) == 0 || len(comp) >= len(data) {
+			t.Fatalf("compress %v: status %v, %v bytes, %q", flags, status, len(comp), stderr)
+		}
+		status, out, stderr := runArgs("decompress "+flags, comp)
+		if status != 0 || !bytes.Equal(out, data) {
+			t.Errorf("decompress %v: status %v, %q, want the data back", flags, status, stderr)
+		}
+	}
+}
+
+func TestStatsAndDisasm(t *testing.T) {
+	status, out, _ := runArgs("stats", []byte("abcabcabcabc"))
+	if status != 0 || !strings.Contains(string(out), "literals 3, backrefs 1, rejected matches 0") {
+		t.Errorf("stats: status %v, %q", status, out)
+	}
+
+	_, comp, _ := runArgs("compress", []byte("abcabcabcabc"))
+	status, out, _ = runArgs("disasm", comp)
+	if status != 0 || strings.Count(string(out), "literal") != 3 ||
+		!strings.Contains(string(out), "backref -3, 9 bytes") {
+		t.Errorf("disasm: status %v, %q", status, out)
+	}
+	if status, _, stderr := runArgs("disasm", []byte{0xb0, 0xd8, 0x81}); status != 1 ||
+		!strings.Contains(stderr, "invalid tokens") {
+		t.Errorf("disasm of a bad stream: status %v, %q, want invalid tokens reported", status, stderr)
+	}
+}
+
+/* Flag values out of range are rejected as they are, not after being cut
+* down to a byte: -w 264 isn't -w 8. */
+func TestBadArgs(t *testing.T) {
+	for _, c := range []struct {
+		args   string
+		status int
+		stderr string
+	}{
+		{"", 2, "usage"},
+		{"squash", 2, "usage"},
+		{"compress -bogus", 2, "not defined"},
+		{"compress -w 264", 1, "invalid window or lookahead size"},
+		{"compress -l 260", 1, "invalid window or lookahead size"},
+		{"compress -w 8 -l 8", 1, "invalid window or lookahead size"},
+		{"compress -w 3", 1, "invalid window or lookahead size"},
+		{"compress -fill 256", 1, "fill must be a byte value"},
+		{"compress -filter delta -stride 0", 1, "stride must be"},
+		{"compress -filter lzma", 1, "unknown filter"},
+		{"compress " + filepath.Join(t.TempDir(), "missing"), 1, "no such file"},
+	} {
+		status, out, stderr := runArgs(c.args, []byte("data"))
+		if status != c.status || len(out) != 0 || !strings.Contains(stderr, c.stderr) {
+			t.Errorf("%q: status %v, %v bytes out, %q; want status %v and %q",
+				c.args, status, len(out), stderr, c.status, c.stderr)
+		}
+	}
+	if status, _, _ := runArgs("compress -h", nil); status != 0 {
+		t.Errorf("-h: status %v, want 0", status)
+	}
+}
@@ -0,0 +1,179 @@
+package heatshrink
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+/* Test data: text, runs, noise and repeats of earlier data, mixed. */
+func test_corpus(seed int64, size int) []byte {
+	words := strings.Fields("the of and to in is that for it as was with be by on not he this are or his from at which but have an they you were her she there had all one")
+	r := rand.New(rand.NewSource(seed))
+	var b []byte
+	for len(b) < size {
+		switch r.Intn(4) {
+		case 0:
+			for n := r.Intn(40); n > 0; n-- {
+				b = append(b, words[r.Intn(len(words))]...)
+				b = append(b, ' ')
+			}
+		case 1:
+			c := []byte{0x00, 0xff, byte(r.Intn(256))}[r.Intn(3)]
+			b = append(b, bytes.Repeat([]byte{c}, r.Intn(300))...)
+		case 2:
+			for n := r.Intn(100); n > 0; n-- {
+				b = append(b, byte(r.Intn(256)))
+			}
+		case 3:
+			if len(b) > 0 {
+				start := r.Intn(len(b))
+				end := start + r.Intn(200)
+				if end > len(b) {
+					end = len(b)
+				}
+				b = append(b, b[start:end]...)
+			}
+		}
+	}
+	return b[:size]
+}
+
+/* SHA-256 of the Compress output of test_corpus(1, 64<<10) for each
+* lookahead size in turn, by window size, as produced by the original
+* encoder. The encoder has been sped up since (the bit accumulator, the
+* relative index links, the ring buffer, skipping over runs), always
+* with byte-identical output. 15 bit windows are left out, as the
+* original encoder's search index overflowed with them. */
+var golden_compress = map[uint8]string{
+	4:  "4fc0f4a6478e732a7577e5cc2930eab170dd549bacb58053ee5ef38441b09971",
+	5:  "6135bf761cd8d9b23548636a0571e67ef7f99b69d82ac72d4355cc202318fc11",
+	6:  "1154c011cadb7159a42b09a45e82655aa70ce23052761e69b77df8ef04f88cf8",
+	7:  "c892b54643f4cfc0fdb7dd8759d789f0470fbd51677c4d5afae7b9a94381f321",
+	8:  "8088282d52612ccba1870ce289968fc508cafa391a42d0224fe85ffea7ed4357",
+	9:  "837ee318903e07cdd703a112a74aacfb87a962552d5877ed99f8c9dcb7076a77",
+	10: "acd3f7212c3622b4534ddfa083f47e2ce198ed358361af8f59963f20e90d6f03",
+	11: "8527291f6c0f994b4fdd603e3fa4f26869c5b4aceb7663b02c13ba85166bbc9d",
+	12: "f1c9431fd96d183669ec868a8fc2717d386f704c63b7c8bc441ab234d09b1170",
+	13: "56fb40456350b7630ea83862dbb18294af2cc9b35df852a4c9646cd4fb710636",
+	14: "c59d425dfb03178c4e6deada0c71feef5a3abbf2362bf5125263c649d2997f1f",
+}
+
+func TestCompressGolden(t *testing.T) {
+	data := test_corpus(1, 64<<10)
+	for w := uint8(HEATSHRINK_MIN_WINDOW_BITS); w < HEATSHRINK_MAX_WINDOW_BITS; w++ {
+		h := sha256.New()
+		for l := uint8(HEATSHRINK_MIN_LOOKAHEAD_BITS); l < w; l++ {
+			h.Write(Compress(w, l, data))
+		}
+		if got := fmt.Sprintf("%x", h.Sum(nil)); got != golden_compress[w] {
+			t.Errorf("window %v: output changed, hash %v", w, got)
+		}
+	}
+}
+
+/* The other ways to compress give the same output as Compress: a
+* pooled encoder in AppendCompress, the Writer fed in pieces, and
+* CompressWithStats. */
+func TestCompressEquivalent(t *testing.T) {
+	data := test_corpus(2, 20000)
+	var dst []byte
+	for w := uint8(HEATSHRINK_MIN_WINDOW_BITS); w <= HEATSHRINK_MAX_WINDOW_BITS; w++ {
+		for l := uint8(HEATSHRINK_MIN_LOOKAHEAD_BITS); l < w; l++ {
+			want := Compress(w, l, data)
+			for i := 0; i < 2; i++ { /* the second time, from the pool */
+				dst = AppendCompress(dst[:0], data, w, l)
+				if !bytes.Equal(dst, want) {
+					t.Errorf("w%vl%v: AppendCompress differs from Compress", w, l)
+				}
+			}
+			if out, _ := CompressWithStats(w, l, data); !bytes.Equal(out, want) {
+				t.Errorf("w%vl%v: CompressWithStats differs from Compress", w, l)
+			}
+			var buf bytes.Buffer
+			zw, _ := NewWriter(&buf, w, l)
+			for p := 0; p < len(data); p += 1000 + p%777 {
+				end := p + 1000 + p%777
+				if end > len(data) {
+					end = len(data)
+				}
+				zw.Write(data[p:end])
+			}
+			zw.Close()
+			if !bytes.Equal(buf.Bytes(), want) {
+				t.Errorf("w%vl%v: Writer differs from Compress", w, l)
+			}
+		}
+	}
+}
+
+/* The fast decoder behind Decompress and the other whole-input decoders
+* must match the state machine behind Reader exactly, on valid streams
+* and on arbitrary input alike. */
+func TestDecompressDifferential(t *testing.T) {
+	data := test_corpus(3, 20000)
+	r := rand.New(rand.NewSource(4))
+	for w := uint8(HEATSHRINK_MIN_WINDOW_BITS); w <= HEATSHRINK_MAX_WINDOW_BITS; w++ {
+		for l := uint8(HEATSHRINK_MIN_LOOKAHEAD_BITS); l < w; l++ {
+			inputs := [][]byte{Compress(w, l, data)}
+			for i := 0; i < 8; i++ {
+				noise := make([]byte, r.Intn(300))
+				r.Read(noise)
+				inputs = append(inputs, noise)
+			}
+			for i, in := range inputs {
+				zr, _ := NewReader(bytes.NewReader(in), w, l)
+				want, err := ioutil.ReadAll(zr)
+				if err != nil && (i == 0 || !errors.Is(err, ErrData)) {
+					t.Fatal(err) /* noise can end in a token */
+				}
+				if i == 0 && !bytes.Equal(want, data) {
+					t.Fatalf("w%vl%v: Reader round trip mismatch", w, l)
+				}
+				if got := Decompress(w, l, in); !bytes.Equal(got, want) {
+					t.Errorf("w%vl%v input %v: Decompress differs from Reader", w, l, i)
+				}
+				if got := AppendDecompress([]byte("x"), in, w, l); !bytes.Equal(got[1:], want) {
+					t.Errorf("w%vl%v input %v: AppendDecompress differs from Reader", w, l, i)
+				}
+				got := make([]byte, len(want))
+				if _, err := DecompressInto(got, w, l, in); !bytes.Equal(got, want) ||
+					(i == 0 && err != nil) {
+					t.Errorf("w%vl%v input %v: DecompressInto differs from Reader (%v)", w, l, i, err)
+				}
+			}
+		}
+	}
+}
+
+/* Positions in the search index state stay within the buffer (or -1)
+* however long the stream: they used to run down with every backlog
+* save, and would wrap around after 2GiB with 32-bit ints. */
+func TestLongStreamPositions(t *testing.T) {
+	data := test_corpus(12, 1<<20)
+	var buf bytes.Buffer
+	zw, _ := NewWriter(&buf, 4, 3)
+	for i := 0; i < 4; i++ {
+		zw.Write(data)
+	}
+	hse := zw.hse
+	if hse.search_run < -1 {
+		t.Errorf("search_run %v", hse.search_run)
+	}
+	for c, pos := range hse.search_last {
+		if pos < -1 {
+			t.Errorf("search_last[0x%02x] %v", c, pos)
+		}
+	}
+	zw.Close()
+	zr, _ := NewReader(&buf, 4, 3)
+	out, _ := ioutil.ReadAll(zr)
+	if !bytes.Equal(out, bytes.Repeat(data, 4)) {
+		t.Errorf("round trip mismatch")
+	}
+}
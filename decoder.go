@@ -3,6 +3,7 @@ package heatshrink
 import (
 	"bytes"
 	"log"
+	"math/bits"
 )
 
 /* States for the polling state machine. */
@@ -50,23 +51,21 @@ type decoder struct {
 	decbuf []byte
 	inbuf  []byte
 	outbuf bytes.Buffer
+
+	input_total int      /* bytes pulled from the input buffer so far */
+	token_bit   int      /* input bit offset of the current token */
+	tokens      *[]Token /* disassembly, collected if non-nil */
 }
 
-func Decompress(window, lookahead uint8, data []byte) []byte {
-	hsd := decoder_alloc(window, lookahead)
-	size := len(data)
-	inlen := 0
-	for {
-		_, tmp := decoder_sink(hsd, data[inlen:])
-		inlen += int(tmp)
-		decoder_poll(hsd)
-		if inlen == size {
-			if decoder_finish(hsd) == HSDR_FINISH_DONE {
-				break
-			}
-		}
+func Decompress(window, lookahead uint8, data []byte, opts ...Option) []byte {
+	o := get_options(opts)
+	flags, ok := filter_flags(o)
+	if !valid_params(window, lookahead) || !ok {
+		return nil
 	}
-	return hsd.outbuf.Bytes()
+	out, _, _ := decode_fast(window, lookahead, preset_dict(o, window, nil), data, nil, -1)
+	filter_decode(flags, out)
+	return out
 }
 
 func decoder_alloc(window_sz2, lookahead_sz2 uint8) *decoder {
@@ -82,7 +81,7 @@ func decoder_alloc(window_sz2, lookahead_sz2 uint8) *decoder {
 	hsd.decbuf = make([]byte, 1<<hsd.window_sz2)
 	hsd.inbuf = make([]byte, 65535)
 	decoder_reset(hsd)
-	log.Printf("-- allocated decoder with buffer size of %v + %v\n",
+	debug_log("-- allocated decoder with buffer size of %v + %v\n",
 		len(hsd.decbuf), len(hsd.inbuf))
 	return hsd
 }
@@ -97,6 +96,12 @@ func decoder_reset(hsd *decoder) {
 	hsd.output_index = 0
 	hsd.head_index = 0
 	hsd.outbuf.Reset()
+	hsd.input_total = 0
+	hsd.token_bit = 0
+	hsd.tokens = nil
+	for i := range hsd.decbuf {
+		hsd.decbuf[i] = 0
+	}
 }
 
 /* Copy SIZE bytes into the decoder's input buffer, if it will fit. */
@@ -110,7 +115,7 @@ func decoder_sink(hsd *decoder, data []byte) (result int, input_size uint16) {
 	if len(data) < int(size) {
 		size = uint16(len(data))
 	}
-	log.Printf("-- sinking %v bytes\n", size)
+	debug_log("-- sinking %v bytes\n", size)
 	/* copy into input buffer (at head of buffers) */
 	copy(hsd.inbuf[hsd.input_size:], data[:size])
 	hsd.input_size += size
@@ -119,7 +124,7 @@ func decoder_sink(hsd *decoder, data []byte) (result int, input_size uint16) {
 
 func decoder_poll(hsd *decoder) int {
 	for {
-		log.Printf("-- poll, state is %v, input_size %v\n",
+		debug_log("-- poll, state is %v, input_size %v\n",
 			hsd.state, hsd.input_size)
 		in_state := hsd.state
 		switch in_state {
@@ -174,6 +179,7 @@ func decoder_finish(hsd *decoder) int {
 }
 
 func dst_tag_bit(hsd *decoder) uint8 {
+	hsd.token_bit = input_bit_offset(hsd)
 	bits := get_bits(hsd, 1) // get tag bit
 	if bits == NO_BITS {
 		return HSDS_TAG_BIT
@@ -197,7 +203,10 @@ func dst_yield_literal(hsd *decoder) uint8 {
 	} /* out of input */
 	mask := uint16(1<<hsd.window_sz2) - 1
 	c := uint8(bits & 0xFF)
-	log.Printf("-- emitting literal byte 0x%02x\n", c)
+	debug_log("-- emitting literal byte 0x%02x\n", c)
+	if hsd.tokens != nil {
+		disasm_literal(hsd, c)
+	}
 	hsd.decbuf[hsd.head_index&mask] = c
 	hsd.head_index++
 	push_byte(hsd, c)
@@ -210,7 +219,7 @@ func dst_backref_index_msb(hsd *decoder) uint8 {
 		log.Fatal("Bit count assert failed.")
 	}
 	bits := get_bits(hsd, bit_ct-8)
-	log.Printf("-- backref index (msb), got 0x%04x (+1)\n", bits)
+	debug_log("-- backref index (msb), got 0x%04x (+1)\n", bits)
 	if bits == NO_BITS {
 		return HSDS_BACKREF_INDEX_MSB
 	}
@@ -224,7 +233,7 @@ func dst_backref_index_lsb(hsd *decoder) uint8 {
 		bit_ct = 8
 	}
 	bits := get_bits(hsd, bit_ct)
-	log.Printf("-- backref index (lsb), got 0x%04x (+1)\n", bits)
+	debug_log("-- backref index (lsb), got 0x%04x (+1)\n", bits)
 	if bits == NO_BITS {
 		return HSDS_BACKREF_INDEX_LSB
 	}
@@ -245,7 +254,7 @@ func dst_backref_count_msb(hsd *decoder) uint8 {
 		log.Fatal("Bit count asser failed.")
 	}
 	bits := get_bits(hsd, br_bit_ct-8)
-	log.Printf("-- backref count (msb), got 0x%04x (+1)\n", bits)
+	debug_log("-- backref count (msb), got 0x%04x (+1)\n", bits)
 	if bits == NO_BITS {
 		return HSDS_BACKREF_COUNT_MSB
 	}
@@ -259,12 +268,24 @@ func dst_backref_count_lsb(hsd *decoder) uint8 {
 		br_bit_ct = 8
 	}
 	bits := get_bits(hsd, br_bit_ct)
-	log.Printf("-- backref count (lsb), got 0x%04x (+1)\n", bits)
+	debug_log("-- backref count (lsb), got 0x%04x (+1)\n", bits)
 	if bits == NO_BITS {
 		return HSDS_BACKREF_COUNT_LSB
 	}
 	hsd.output_count |= bits
 	hsd.output_count++
+	if hsd.tokens != nil {
+		disasm_backref(hsd)
+	}
+	if hsd.output_index == 1 && hsd.output_count == 1 {
+		/* A 1-byte backref is never worth encoding, so the encoder
+		* only emits one (with index 0) as a sync marker, followed by
+		* padding up to the next byte boundary. */
+		debug_log("-- sync marker, skipping to next byte\n")
+		hsd.output_count = 0
+		hsd.bit_index = 0x00
+		return HSDS_TAG_BIT
+	}
 	return HSDS_YIELD_BACKREF
 }
 
@@ -272,7 +293,7 @@ func dst_yield_backref(hsd *decoder) uint8 {
 	count := hsd.output_count
 	mask := uint16(1<<hsd.window_sz2) - 1
 	neg_offset := hsd.output_index
-	log.Printf("-- emitting %v bytes from -%v bytes back\n", count, neg_offset)
+	debug_log("-- emitting %v bytes from -%v bytes back\n", count, neg_offset)
 	if neg_offset > mask+1 {
 		log.Fatal("neg_offset assert failed.")
 	}
@@ -285,7 +306,7 @@ func dst_yield_backref(hsd *decoder) uint8 {
 		push_byte(hsd, c)
 		hsd.decbuf[hsd.head_index&mask] = c
 		hsd.head_index++
-		log.Printf("  -- ++ 0x%02x\n", c)
+		debug_log("  -- ++ 0x%02x\n", c)
 	}
 	hsd.output_count -= count
 	if hsd.output_count == 0 {
@@ -301,7 +322,7 @@ func get_bits(hsd *decoder, count uint8) uint16 {
 	if count > 15 {
 		return NO_BITS
 	}
-	log.Printf("-- popping %v bit(s)\n", count)
+	debug_log("-- popping %v bit(s)\n", count)
 
 	/* If we aren't able to get COUNT bits, suspend immediately, because we
 	* don't track how many bits of COUNT we've accumulated before suspend. */
@@ -314,13 +335,14 @@ func get_bits(hsd *decoder, count uint8) uint16 {
 	for i := uint8(0); i < count; i++ {
 		if hsd.bit_index == 0x00 {
 			if hsd.input_size == 0 {
-				log.Printf("  -- out of bits, suspending w/ accumulator of %v (0x%02x)\n",
+				debug_log("  -- out of bits, suspending w/ accumulator of %v (0x%02x)\n",
 					accumulator, accumulator)
 				return NO_BITS
 			}
 			hsd.current_byte = hsd.inbuf[hsd.input_index]
 			hsd.input_index++
-			log.Printf("  -- pulled byte 0x%02x\n", hsd.current_byte)
+			hsd.input_total++
+			debug_log("  -- pulled byte 0x%02x\n", hsd.current_byte)
 			if hsd.input_index == hsd.input_size {
 				hsd.input_index = 0 /* input is exhausted */
 				hsd.input_size = 0
@@ -335,12 +357,18 @@ func get_bits(hsd *decoder, count uint8) uint16 {
 	}
 
 	if count > 1 {
-		log.Printf("  -- accumulated %08x\n", accumulator)
+		debug_log("  -- accumulated %08x\n", accumulator)
 	}
 	return accumulator
 }
 
+/* Offset of the next input bit to be read, counted from the start of
+* the stream. */
+func input_bit_offset(hsd *decoder) int {
+	return 8*hsd.input_total - bits.Len8(hsd.bit_index)
+}
+
 func push_byte(hsd *decoder, byte uint8) {
-	log.Printf(" -- pushing byte: 0x%02x\n", byte)
+	debug_log(" -- pushing byte: 0x%02x\n", byte)
 	hsd.outbuf.WriteByte(byte)
 }
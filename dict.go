@@ -0,0 +1,102 @@
+package heatshrink
+
+/* A preset window makes the encoder and decoder start out as if the
+* dictionary had just been processed: backrefs at the start of the data
+* can then point into it. Both keep the window as it would be laid out
+* after the dictionary: the encoder in the backlog half of its buffer,
+* the decoder in its window buffer with head_index at 0. So in both, the
+* last byte of the dictionary ends up at the end of a 1<<window_sz2 byte
+* buffer. Without a preset that buffer is all zeros.
+*
+* The window options preset the window in the same way, for streams made
+* by (or for) heatshrink forks that start with something other than
+* zeros in the window. A dictionary goes over them, where it reaches. */
+
+// WithWindowFill makes the encoder and decoder start with a window full
+// of c rather than zeros. Both ends must agree on it: the encoder makes
+// backrefs into the initial window where that pays off (so data starting
+// with a run of c compresses better), and the decoder reproduces them.
+// The window options apply to Compress, CompressWithDict,
+// CompressWithStats, AppendCompress and NewWriter, and to the matching
+// decoders, down to NewReader; the variants and members ignore them.
+func WithWindowFill(c byte) Option {
+	return func(o *options) {
+		o.fill = c
+	}
+}
+
+// WithWindow makes the encoder and decoder start with window in the
+// window, aligned to its end as a preset dictionary is, and the fill
+// byte (see WithWindowFill) before it. Unlike a dictionary, it doesn't
+// depend on the data: it is for matching how other implementations set
+// up their window. window isn't copied, and must not change while in
+// use.
+func WithWindow(window []byte) Option {
+	return func(o *options) {
+		o.preset = window
+	}
+}
+
+/* Drops the window options given before it. */
+func without_window(o *options) {
+	o.fill = 0
+	o.preset = nil
+}
+
+/* The preset window for the window options in o, and dict over them: a
+* whole window, or dict itself (nil for none) without window options. */
+func preset_dict(o *options, window_sz2 uint8, dict []byte) []byte {
+	if o.fill == 0 && o.preset == nil {
+		return dict
+	}
+	window := make([]byte, 1<<window_sz2)
+	if o.fill != 0 {
+		for i := range window {
+			window[i] = o.fill
+		}
+	}
+	preset_window(window, o.preset)
+	preset_window(window, dict)
+	return window
+}
+
+// CompressWithDict compresses data as if dict had been compressed right
+// before it, so that data can refer back to the last 2^window bytes of
+// dict. The output decodes with DecompressWithDict and the same dict.
+func CompressWithDict(window, lookahead uint8, dict, data []byte, opts ...Option) []byte {
+	if !valid_params(window, lookahead) {
+		return nil
+	}
+	hse := encoder_alloc(window, lookahead)
+	encoder_options(hse, opts)
+	encoder_preset(hse, preset_dict(get_options(opts), window, dict))
+	return compress(hse, data)
+}
+
+// DecompressWithDict decompresses data compressed by CompressWithDict.
+func DecompressWithDict(window, lookahead uint8, dict, data []byte, opts ...Option) []byte {
+	if !valid_params(window, lookahead) {
+		return nil
+	}
+	dict = preset_dict(get_options(opts), window, dict)
+	out, _, _ := decode_fast(window, lookahead, dict, data, nil, -1)
+	return out
+}
+
+func encoder_preset(hse *encoder, dict []byte) {
+	/* Only done before any input, when head is still 0. */
+	preset_window(hse.buffer[:get_input_offset(hse)], dict)
+	buffer_mirror(hse)
+}
+
+func decoder_preset(hsd *decoder, dict []byte) {
+	preset_window(hsd.decbuf, dict)
+}
+
+/* Fill window with the tail of dict, aligned to its end. */
+func preset_window(window, dict []byte) {
+	if len(dict) > len(window) {
+		dict = dict[len(dict)-len(window):]
+	}
+	copy(window[len(window)-len(dict):], dict)
+}
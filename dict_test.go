@@ -0,0 +1,35 @@
+package heatshrink
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+/* The initial window, from the window options or a dictionary, as seen
+* by all the decoders. */
+func TestWindowRoundTrip(t *testing.T) {
+	data := append(bytes.Repeat([]byte{0xa5}, 100), test_corpus(9, 5000)...)
+	dict := test_corpus(10, 3000)
+	for _, opts := range [][]Option{
+		{WithWindowFill(0xa5)},
+		{WithWindow(data[100:400])},
+		{WithWindowFill(0xa5), WithWindow([]byte("hello"))},
+	} {
+		comp := Compress(8, 4, data, opts...)
+		if len(comp) > len(Compress(8, 4, data)) {
+			t.Errorf("%v: larger than without", get_options(opts))
+		}
+		if got := Decompress(8, 4, comp, opts...); !bytes.Equal(got, data) {
+			t.Errorf("%v: Decompress mismatch", get_options(opts))
+		}
+		zr, _ := NewReader(bytes.NewReader(comp), 8, 4, opts...)
+		if got, _ := ioutil.ReadAll(zr); !bytes.Equal(got, data) {
+			t.Errorf("%v: Reader mismatch", get_options(opts))
+		}
+		comp = CompressWithDict(8, 4, dict, data, opts...)
+		if got := DecompressWithDict(8, 4, dict, comp, opts...); !bytes.Equal(got, data) {
+			t.Errorf("%v: DecompressWithDict mismatch", get_options(opts))
+		}
+	}
+}
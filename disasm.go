@@ -0,0 +1,126 @@
+package heatshrink
+
+import (
+	"fmt"
+	"math/bits"
+)
+
+// TokenKind tells what a Token is.
+type TokenKind uint8
+
+const (
+	TokenLiteral  TokenKind = iota /* literal byte */
+	TokenBackref                   /* back-reference into the window */
+	TokenSync                      /* sync marker, see Writer.Flush */
+	TokenTrailing                  /* bits at the end that don't make up a token */
+)
+
+// Token is a single element of a compressed stream, as reported by
+// Disassemble.
+type Token struct {
+	Kind   TokenKind
+	Bit    int  /* bit offset in the input */
+	Bits   int  /* size in bits */
+	Pos    int  /* output position of the first byte produced */
+	Byte   byte /* literal value */
+	Offset int  /* backref distance */
+	Length int  /* backref length */
+
+	/* PreWindow flags a backref reaching back before the start of the
+	* output, into the initial window (all zeros, unless set up by the
+	* window options or a dictionary). Encoders make these on purpose,
+	* e.g. for data starting with a run of zeros, so it isn't an error. */
+	PreWindow bool
+
+	/* Invalid flags trailing bits that aren't all zero padding. */
+	Invalid bool
+}
+
+// Disassemble decodes data and lists the tokens it is made of, rather
+// than the output. It keeps going past suspicious tokens, flagging them
+// Invalid, so it can be used to investigate streams that don't decode
+// as expected. opts are those given to the decoder; only the window
+// options make a difference, to what a PreWindow backref refers to.
+func Disassemble(window, lookahead uint8, data []byte, opts ...Option) []Token {
+	hsd := decoder_alloc(window, lookahead)
+	if hsd == nil {
+		return nil
+	}
+	decoder_preset(hsd, preset_dict(get_options(opts), window, nil))
+	tokens := []Token{}
+	hsd.tokens = &tokens
+	for inlen := 0; inlen < len(data); {
+		_, sz := decoder_sink(hsd, data[inlen:])
+		inlen += int(sz)
+		decoder_poll(hsd)
+	}
+
+	/* Whatever is left after the last complete token should be the
+	* 0-bit padding of the last byte. */
+	if end := 8 * len(data); hsd.token_bit < end {
+		t := Token{Kind: TokenTrailing, Bit: hsd.token_bit, Bits: end - hsd.token_bit,
+			Pos: hsd.outbuf.Len()}
+		for i := t.Bit; i < end; i++ {
+			if data[i/8]&(0x80>>uint(i%8)) != 0 {
+				t.Invalid = true
+			}
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens
+}
+
+func disasm_literal(hsd *decoder, c uint8) {
+	*hsd.tokens = append(*hsd.tokens, Token{
+		Kind: TokenLiteral,
+		Bit:  hsd.token_bit,
+		Bits: input_bit_offset(hsd) - hsd.token_bit,
+		Pos:  hsd.outbuf.Len(),
+		Byte: c,
+	})
+}
+
+func disasm_backref(hsd *decoder) {
+	t := Token{
+		Kind:   TokenBackref,
+		Bit:    hsd.token_bit,
+		Bits:   input_bit_offset(hsd) - hsd.token_bit,
+		Pos:    hsd.outbuf.Len(),
+		Offset: int(hsd.output_index),
+		Length: int(hsd.output_count),
+	}
+	if t.Offset == 1 && t.Length == 1 {
+		t.Kind = TokenSync
+		t.Offset, t.Length = 0, 0
+		/* The padding up to the next byte belongs to the marker. */
+		t.Bits += bits.Len8(hsd.bit_index)
+	} else if t.Offset > t.Pos {
+		t.PreWindow = true
+	}
+	*hsd.tokens = append(*hsd.tokens, t)
+}
+
+func (t Token) String() string {
+	var s string
+	switch t.Kind {
+	case TokenLiteral:
+		s = fmt.Sprintf("%8v: literal 0x%02x", t.Bit, t.Byte)
+		if t.Byte >= 0x20 && t.Byte < 0x7f {
+			s += fmt.Sprintf(" %q", t.Byte)
+		}
+	case TokenBackref:
+		s = fmt.Sprintf("%8v: backref -%v, %v bytes", t.Bit, t.Offset, t.Length)
+	case TokenSync:
+		s = fmt.Sprintf("%8v: sync marker", t.Bit)
+	case TokenTrailing:
+		s = fmt.Sprintf("%8v: %v trailing bits", t.Bit, t.Bits)
+	}
+	s += fmt.Sprintf(" -> @%v", t.Pos)
+	if t.PreWindow {
+		s += " (pre-window)"
+	}
+	if t.Invalid {
+		s += " INVALID"
+	}
+	return s
+}
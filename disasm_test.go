@@ -0,0 +1,31 @@
+package heatshrink
+
+import (
+	"bytes"
+	"testing"
+)
+
+/* Backrefs into the initial window are what the encoder makes for data
+* starting with the fill byte: flagged PreWindow, but not Invalid. */
+func TestDisassemblePreWindow(t *testing.T) {
+	for _, c := range []byte{0x00, 0xff} {
+		data := append(bytes.Repeat([]byte{c}, 40), "hello hello hello"...)
+		opts := []Option{WithWindowFill(c)}
+		comp := Compress(8, 4, data, opts...)
+		tokens := Disassemble(8, 4, comp, opts...)
+		if len(tokens) == 0 || !tokens[0].PreWindow {
+			t.Fatalf("fill 0x%02x: first token %v, want a pre-window backref", c, tokens)
+		}
+		for _, tok := range tokens {
+			if tok.Invalid {
+				t.Errorf("fill 0x%02x: %v flagged invalid", c, tok)
+			}
+		}
+	}
+
+	/* Non-zero padding still is invalid. */
+	tokens := Disassemble(8, 4, []byte{0xb0, 0xd8, 0x81})
+	if last := tokens[len(tokens)-1]; last.Kind != TokenTrailing || !last.Invalid {
+		t.Errorf("last token %v, want invalid trailing bits", last)
+	}
+}
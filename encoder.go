@@ -3,6 +3,7 @@ package heatshrink
 import (
 	"bytes"
 	"log"
+	"math/bits"
 )
 
 const (
@@ -24,35 +25,42 @@ const (
 )
 
 type encoder struct {
-	input_size          uint16 /* bytes in input buffer */
-	match_scan_index    uint16
-	match_length        uint16
-	match_pos           uint16
-	outgoing_bits       uint16 /* enqueued outgoing bits */
-	outgoing_bits_count uint8
-	finishing           bool
-	state               uint8 /* current state machine node */
-	current_byte        uint8 /* current byte of output */
-	bit_index           uint8 /* current bit index */
-	window_sz2          uint8 /* 2^n size of window */
-	lookahead_sz2       uint8 /* 2^n size of lookahead */
-	search_index        []int16
-	buffer              []byte
-	outbuf              bytes.Buffer
+	input_size       uint16 /* bytes in input buffer */
+	match_scan_index uint16
+	match_length     uint16
+	match_pos        uint16
+	finishing        bool
+	flushing         bool
+	state            uint8    /* current state machine node */
+	current_byte     uint8    /* current byte of output */
+	bit_index        uint8    /* current bit index */
+	window_sz2       uint8    /* 2^n size of window */
+	lookahead_sz2    uint8    /* 2^n size of lookahead */
+	min_match        uint16   /* shortest match used as a backref, see options.go */
+	search_index     []uint16 /* distance back to the previous instance of each byte */
+	search_last      [256]int /* position of the last instance of each byte */
+	search_run       int      /* position of the first byte of the last run */
+	indexed          int      /* bytes of buffer indexed so far */
+	buffer           []byte   /* circular, see buffer_at */
+	head             int      /* offset in buffer (and search_index) of byte 0 */
+	outbuf           bytes.Buffer
+	stats            *Stats           /* collected if non-nil */
+	entropy          *entropy_encoder /* tokens are coded here instead of pushed as bits, if non-nil */
 }
 
 // Internal state machine states
 const (
-	HSES_NOT_FULL        = iota /* input buffer not full enough */
-	HSES_FILLED                 /* buffer is full */
-	HSES_SEARCH                 /* searching for patterns */
-	HSES_YIELD_TAG_BIT          /* yield tag bit */
-	HSES_YIELD_LITERAL          /* emit literal byte */
-	HSES_YIELD_BR_INDEX         /* yielding backref index */
-	HSES_YIELD_BR_LENGTH        /* yielding backref length */
-	HSES_SAVE_BACKLOG           /* copying buffer to backlog */
-	HSES_FLUSH_BITS             /* flush bit buffer */
-	HSES_DONE                   /* done */
+	HSES_NOT_FULL      = iota /* input buffer not full enough */
+	HSES_FILLED               /* buffer is full */
+	HSES_SEARCH               /* searching for patterns */
+	HSES_YIELD_TAG_BIT        /* yield a whole token */
+	_                         /* (the states that yielded a token a few */
+	_                         /* bits at a time, numbers kept as they */
+	_                         /* are in snapshots) */
+	HSES_SAVE_BACKLOG         /* copying buffer to backlog */
+	HSES_FLUSH_BITS           /* flush bit buffer */
+	HSES_DONE                 /* done */
+	HSES_SYNC_BITS            /* byte-align output mid-stream */
 )
 
 const (
@@ -61,8 +69,19 @@ const (
 	HEATSHRINK_BACKREF_MARKER = 0x00
 )
 
-func Compress(window, lookahead uint8, data []byte) []byte {
+func Compress(window, lookahead uint8, data []byte, opts ...Option) []byte {
+	o := get_options(opts)
+	flags, ok := filter_flags(o)
+	if !valid_params(window, lookahead) || !ok {
+		return nil
+	}
 	hse := encoder_alloc(window, lookahead)
+	encoder_options(hse, opts)
+	encoder_preset(hse, preset_dict(o, window, nil))
+	return compress(hse, filter_encode(flags, data))
+}
+
+func compress(hse *encoder, data []byte) []byte {
 	size := len(data)
 	inlen := 0
 	for {
@@ -96,10 +115,11 @@ func encoder_alloc(window_sz2, lookahead_sz2 uint8) *encoder {
 	hse.window_sz2 = window_sz2
 	hse.lookahead_sz2 = lookahead_sz2
 	encoder_reset(hse)
-	hse.buffer = make([]byte, buf_sz)
-	hse.search_index = make([]int16, buf_sz)
+	hse.buffer = make([]byte, buf_sz+(1<<lookahead_sz2)) /* see buffer_at */
+	hse.search_index = make([]uint16, buf_sz)
+	encoder_options(hse, nil)
 
-	log.Printf("-- allocated encoder with buffer size of %v (%v byte input size)\n",
+	debug_log("-- allocated encoder with buffer size of %v (%v byte input size)\n",
 		buf_sz, get_input_buffer_size(hse))
 	return hse
 }
@@ -109,12 +129,22 @@ func encoder_reset(hse *encoder) {
 	hse.state = HSES_NOT_FULL
 	hse.match_scan_index = 0
 	hse.finishing = false
+	hse.flushing = false
 	hse.bit_index = 0x80
 	hse.current_byte = 0x00
 	hse.match_length = 0
-	hse.outgoing_bits = 0x0000
-	hse.outgoing_bits_count = 0
 	hse.outbuf.Reset()
+	hse.stats = nil
+	hse.entropy = nil
+	hse.indexed = 0
+	hse.search_run = 0
+	hse.head = 0
+	for i := range hse.search_last {
+		hse.search_last[i] = -1
+	}
+	for i := range hse.buffer {
+		hse.buffer[i] = 0
+	}
 }
 
 func encoder_sink(hse *encoder, in_buf []byte) (result int, input_size uint16) {
@@ -136,13 +166,13 @@ func encoder_sink(hse *encoder, in_buf []byte) (result int, input_size uint16) {
 		cp_sz = uint16(len(in_buf))
 	}
 
-	copy(hse.buffer[write_offset:], in_buf[:cp_sz])
+	buffer_write(hse, int(write_offset), in_buf[:cp_sz])
 	hse.input_size += cp_sz
 
-	log.Printf("-- sunk %v bytes (of %v) into encoder at %v, input buffer now has %v\n",
+	debug_log("-- sunk %v bytes (of %v) into encoder at %v, input buffer now has %v\n",
 		cp_sz, len(in_buf), write_offset, hse.input_size)
 	if cp_sz == rem {
-		log.Printf("-- internal buffer is now full\n")
+		debug_log("-- internal buffer is now full\n")
 		hse.state = HSES_FILLED
 	}
 
@@ -151,7 +181,7 @@ func encoder_sink(hse *encoder, in_buf []byte) (result int, input_size uint16) {
 
 func encoder_poll(hse *encoder) int {
 	for {
-		log.Printf("-- polling, state %v, finishing %v\n",
+		debug_log("-- polling, state %v, finishing %v\n",
 			hse.state, hse.finishing)
 		switch in_state := hse.state; in_state {
 		case HSES_NOT_FULL:
@@ -163,27 +193,23 @@ func encoder_poll(hse *encoder) int {
 			hse.state = est_step_search(hse)
 		case HSES_YIELD_TAG_BIT:
 			hse.state = est_yield_tag_bit(hse)
-		case HSES_YIELD_LITERAL:
-			hse.state = est_yield_literal(hse)
-		case HSES_YIELD_BR_INDEX:
-			hse.state = est_yield_br_index(hse)
-		case HSES_YIELD_BR_LENGTH:
-			hse.state = est_yield_br_length(hse)
 		case HSES_SAVE_BACKLOG:
 			hse.state = est_save_backlog(hse)
 		case HSES_FLUSH_BITS:
 			hse.state = est_flush_bit_buffer(hse)
+		case HSES_SYNC_BITS:
+			hse.state = est_sync_bit_buffer(hse)
 		case HSES_DONE:
 			return HSER_POLL_EMPTY
 		default:
-			log.Printf("-- bad state %v\n", hse.state)
+			debug_log("-- bad state %v\n", hse.state)
 			return HSER_POLL_ERROR_MISUSE
 		}
 	}
 }
 
 func encoder_finish(hse *encoder) int {
-	log.Printf("-- setting is_finishing flag\n")
+	debug_log("-- setting is_finishing flag\n")
 	hse.finishing = true
 	if hse.state == HSES_NOT_FULL {
 		hse.state = HSES_FILLED
@@ -195,23 +221,42 @@ func encoder_finish(hse *encoder) int {
 	}
 }
 
+/* Process everything sunk so far and byte-align the output without
+* ending the stream, so that the decoder can produce all of it while
+* the window is kept for the data that follows. */
+func encoder_flush(hse *encoder) int {
+	if is_finishing(hse) {
+		return HSER_POLL_ERROR_MISUSE
+	}
+	debug_log("-- setting flushing flag\n")
+	hse.flushing = true
+	if hse.state == HSES_NOT_FULL {
+		hse.state = HSES_FILLED
+	}
+	return encoder_poll(hse)
+}
+
 func est_step_search(hse *encoder) uint8 {
 	window_length := get_input_buffer_size(hse)
 	lookahead_sz := get_lookahead_size(hse)
 	msi := hse.match_scan_index
-	log.Printf("## step_search, scan @ +%v (%v/%v), input size %v\n",
+	debug_log("## step_search, scan @ +%v (%v/%v), input size %v\n",
 		msi, hse.input_size+msi, 2*window_length, hse.input_size)
 
 	bias := lookahead_sz
-	if is_finishing(hse) {
+	if is_finishing(hse) || hse.flushing {
 		bias = 1
 	}
-	if msi > hse.input_size-bias {
+	/* Compare as int: input_size can be smaller than bias when
+	* finishing or flushing with little (or no) input left. */
+	if int(msi) > int(hse.input_size)-int(bias) {
 		/* Current search buffer is exhausted, copy it into the
 		* backlog and await more input. */
-		log.Printf("-- end of search @ %v\n", msi)
+		debug_log("-- end of search @ %v\n", msi)
 		if is_finishing(hse) {
 			return HSES_FLUSH_BITS
+		} else if hse.flushing {
+			return HSES_SYNC_BITS
 		} else {
 			return HSES_SAVE_BACKLOG
 		}
@@ -228,12 +273,12 @@ func est_step_search(hse *encoder) uint8 {
 	match_pos, match_length := find_longest_match(hse, start, end, max_possible)
 
 	if match_pos == MATCH_NOT_FOUND {
-		log.Printf("ss Match not found\n")
+		debug_log("ss Match not found\n")
 		hse.match_scan_index++
 		hse.match_length = 0
 		return HSES_YIELD_TAG_BIT
 	} else {
-		log.Printf("ss Found match of %v bytes at %v\n", match_length, match_pos)
+		debug_log("ss Found match of %v bytes at %v\n", match_length, match_pos)
 		hse.match_pos = match_pos
 		hse.match_length = match_length
 		if match_pos > 1<<hse.window_sz2 /*window_length*/ {
@@ -243,66 +288,83 @@ func est_step_search(hse *encoder) uint8 {
 	}
 }
 
+/* Yield the whole token, tag bit and all, in one go. */
 func est_yield_tag_bit(hse *encoder) uint8 {
+	if hse.stats != nil {
+		stats_add_token(hse)
+	}
+	if hse.entropy != nil {
+		entropy_add_token(hse)
+		if hse.match_length == 0 {
+			return HSES_SEARCH
+		}
+		return yield_backref_done(hse)
+	}
 	if hse.match_length == 0 {
-		add_tag_bit(hse, HEATSHRINK_LITERAL_MARKER)
-		return HSES_YIELD_LITERAL
-	} else {
-		add_tag_bit(hse, HEATSHRINK_BACKREF_MARKER)
-		hse.outgoing_bits = hse.match_pos - 1
-		hse.outgoing_bits_count = hse.window_sz2
-		return HSES_YIELD_BR_INDEX
+		push_bits(hse, 9, HEATSHRINK_LITERAL_MARKER<<8|uint32(literal_byte(hse)))
+		return HSES_SEARCH
 	}
+	debug_log("-- yielding backref index %v, length %v\n", hse.match_pos, hse.match_length)
+	push_bits(hse, 1+hse.window_sz2+hse.lookahead_sz2,
+		HEATSHRINK_BACKREF_MARKER<<(hse.window_sz2+hse.lookahead_sz2)|
+			uint32(hse.match_pos-1)<<hse.lookahead_sz2|
+			uint32(hse.match_length-1))
+	return yield_backref_done(hse)
 }
 
-func est_yield_literal(hse *encoder) uint8 {
-	push_literal_byte(hse)
+func yield_backref_done(hse *encoder) uint8 {
+	hse.match_scan_index += hse.match_length
+	hse.match_length = 0
 	return HSES_SEARCH
 }
 
-func est_yield_br_index(hse *encoder) uint8 {
-	log.Printf("-- yielding backref index %v\n", hse.match_pos)
-	if push_outgoing_bits(hse) > 0 {
-		return HSES_YIELD_BR_INDEX /* continue */
-	} else {
-		hse.outgoing_bits = hse.match_length - 1
-		hse.outgoing_bits_count = hse.lookahead_sz2
-		return HSES_YIELD_BR_LENGTH /* done */
-	}
-}
-
-func est_yield_br_length(hse *encoder) uint8 {
-	log.Printf("-- yielding backref length %v\n", hse.match_length)
-	if push_outgoing_bits(hse) > 0 {
-		return HSES_YIELD_BR_LENGTH
-	} else {
-		hse.match_scan_index += hse.match_length
-		hse.match_length = 0
-		return HSES_SEARCH
-	}
-}
-
 func est_save_backlog(hse *encoder) uint8 {
-	log.Printf("-- saving backlog\n")
+	debug_log("-- saving backlog\n")
 	save_backlog(hse)
 	return HSES_NOT_FULL
 }
 
 func est_flush_bit_buffer(hse *encoder) uint8 {
 	if hse.bit_index == 0x80 {
-		log.Printf("-- done!\n")
+		debug_log("-- done!\n")
 		return HSES_DONE
 	} else {
-		log.Printf("-- flushing remaining byte (bit_index == 0x%02x)\n", hse.bit_index)
+		debug_log("-- flushing remaining byte (bit_index == 0x%02x)\n", hse.bit_index)
+		if hse.stats != nil {
+			stats_add_padding(hse)
+		}
 		hse.outbuf.WriteByte(hse.current_byte)
-		log.Printf("-- done!\n")
+		debug_log("-- done!\n")
 		return HSES_DONE
 	}
 }
 
-func add_tag_bit(hse *encoder, tag uint8) {
-	log.Printf("-- adding tag bit: %v\n", tag)
-	push_bits(hse, 1, tag)
+/* Byte-align the output in the middle of a stream. A partial byte can't
+* simply be padded with 0s, since the decoder would read them as the
+* start of a backref and run on into the next byte. Instead, emit a
+* backref with index 0 and count 0 (offset 1, length 1), which is never
+* worth encoding and thus never produced by the search, then pad to the
+* byte boundary. The decoder takes that token as a sync marker and skips
+* the rest of the byte. */
+func est_sync_bit_buffer(hse *encoder) uint8 {
+	if hse.bit_index != 0x80 {
+		debug_log("-- pushing sync marker (bit_index == 0x%02x)\n", hse.bit_index)
+		if hse.stats != nil {
+			hse.stats.PaddingBits += 1 + int(hse.window_sz2) + int(hse.lookahead_sz2)
+		}
+		push_bits(hse, 1+hse.window_sz2+hse.lookahead_sz2, 0)
+		if hse.bit_index != 0x80 {
+			if hse.stats != nil {
+				stats_add_padding(hse)
+			}
+			hse.outbuf.WriteByte(hse.current_byte)
+			hse.current_byte = 0x00
+			hse.bit_index = 0x80
+		}
+	}
+	hse.flushing = false
+	save_backlog(hse)
+	return HSES_NOT_FULL
 }
 
 func get_input_offset(hse *encoder) uint16 {
@@ -322,34 +384,48 @@ func do_indexing(hse *encoder) {
 	* for the previous instances of every byte in the buffer.
 	*
 	* For example, if buf[200] == 'x', then index[200] will either
-	* be an offset i such that buf[i] == 'x', or a negative offset
-	* to indicate end-of-list. This significantly speeds up matching,
-	* while only using sizeof(uint16_t)*sizeof(buffer) bytes of RAM.
+	* be a distance d such that buf[200-d] == 'x', or 0 to indicate
+	* end-of-list. This significantly speeds up matching, while only
+	* using sizeof(uint16_t)*sizeof(buffer) bytes of RAM.
 	*
-	* Future optimization options:
-	* 1. Since any negative value represents end-of-list, the other
-	*    15 bits could be used to improve the index dynamically.
+	* As the links are relative, they stay valid when save_backlog
+	* moves the start of the buffer (and the index along with it) on, so
+	* only the bytes added since the last time need indexing. Links that
+	* now point before the start of the buffer end the list.
 	*
-	* 2. Likewise, the last lookahead_sz bytes of the index will
-	*    not be usable, so temporary data could be stored there to
-	*    dynamically improve the index.
+	* Bytes that repeat the one before them link back to the first byte
+	* of their run instead, so a search can step over a run in one go,
+	* see find_longest_match.
 	* */
-	last := [256]int16{}
-	for i := range last {
-		last[i] = -1
-	}
 	data := hse.buffer
 	index := hse.search_index
+	last := &hse.search_last
+	mask := len(index) - 1
 
 	input_offset := get_input_offset(hse)
-	end := input_offset + hse.input_size
+	end := int(input_offset) + int(hse.input_size)
 
-	for i := 0; i < int(end); i++ {
-		v := data[i]
+	for i := hse.indexed; i < end; i++ {
+		j := (hse.head + i) & mask
+		v := data[j]
 		lv := last[v]
-		index[i] = lv
-		last[v] = int16(i)
+		switch {
+		case lv >= 0 && lv == i-1:
+			if i-hse.search_run > mask {
+				index[j] = uint16(mask) /* well before the start anyway */
+			} else {
+				index[j] = uint16(i - hse.search_run)
+			}
+		case lv >= 0:
+			index[j] = uint16(i - lv)
+			hse.search_run = i
+		default:
+			index[j] = 0
+			hse.search_run = i
+		}
+		last[v] = i
 	}
+	hse.indexed = end
 }
 
 func is_finishing(hse *encoder) bool {
@@ -359,25 +435,69 @@ func is_finishing(hse *encoder) bool {
 /* Return the longest match for the bytes at buf[end:end+maxlen] between
 * buf[start] and buf[end-1]. If no match is found, return -1. */
 func find_longest_match(hse *encoder, start, end, maxlen uint16) (match_pos, match_length uint16) {
-	log.Printf("-- scanning for match of buf[%v:%v] between buf[%v:%v] (max %v bytes)\n",
+	debug_log("-- scanning for match of buf[%v:%v] between buf[%v:%v] (max %v bytes)\n",
 		end, end+maxlen, start, end+maxlen-1, maxlen)
 
 	match_maxlen := uint16(0)
 	match_index := MATCH_NOT_FOUND
 
+	/* The buffer is circular, but maxlen bytes from any offset can be
+	* read straight through, see buffer_at. */
+	mask := len(hse.search_index) - 1
 	len := uint16(0)
-	needlepoint := hse.buffer[end:]
-	pos := hse.search_index[end]
+	needlepoint := hse.buffer[(hse.head+int(end))&mask:]
+	pos := int(end)
+
+	/* Runs of a repeated byte c: say the needle starts with run bytes
+	* of c. If the byte before the needle is c too, the run it ends
+	* gives a distance-1 match of run bytes, and no other position in it
+	* gives a longer one (the byte that ends the needle's run ends its
+	* match too). In an earlier run of c, only one position can give a
+	* match longer than the others in the run: run bytes before its end,
+	* or its first byte if it is shorter than that. As bytes in a run
+	* link back to its first byte (see do_indexing), the search takes
+	* just that position of each run, instead of walking through the run
+	* one byte at a time. Of equally long matches the nearest is still
+	* taken, so the result is the same. */
+	c := needlepoint[0]
+	run := uint16(1)
+	for run < maxlen && needlepoint[run] == c {
+		run++
+	}
+	if end > start && buffer_at(hse, pos-1) == c {
+		match_maxlen = run
+		match_index = end - 1
+		if run == maxlen {
+			pos = int(start) /* won't find better */
+		}
+	}
 
-	for int16(pos)-int16(start) >= 0 {
-		pospoint := hse.buffer[pos:]
+	for {
+		dist := int(hse.search_index[(hse.head+pos)&mask])
+		if dist == 0 || pos-dist < int(start) {
+			break
+		}
+		pos -= dist
+		cand := pos
+		if pos > int(start) && buffer_at(hse, pos-1) == c {
+			/* pos ends an earlier run, go on from its first byte */
+			pos -= int(hse.search_index[(hse.head+pos)&mask])
+			if pos < int(start) {
+				pos = int(start)
+			}
+			if cand+1-int(run) > pos {
+				cand = cand + 1 - int(run)
+			} else {
+				cand = pos
+			}
+		}
+		pospoint := hse.buffer[(hse.head+cand)&mask:]
 		len = 0
 
 		/* Only check matches that will potentially beat the current maxlen.
 		* This is redundant with the index if match_maxlen is 0, but the
 		* added branch overhead to check if it == 0 seems to be worse. */
 		if pospoint[match_maxlen] != needlepoint[match_maxlen] {
-			pos = hse.search_index[pos]
 			continue
 		}
 
@@ -389,93 +509,118 @@ func find_longest_match(hse *encoder, start, end, maxlen uint16) (match_pos, mat
 
 		if len > match_maxlen {
 			match_maxlen = len
-			match_index = uint16(pos)
+			match_index = uint16(cand)
 			if len == maxlen {
 				break
 			} /* won't find better */
 		}
-		pos = hse.search_index[pos]
 	}
 
-	break_even_point := 1 + uint16(hse.window_sz2) + uint16(hse.lookahead_sz2)
-
-	/* Instead of comparing break_even_point against 8*match_maxlen,
-	* compare match_maxlen against break_even_point/8 to avoid
-	* overflow. Since MIN_WINDOW_BITS and MIN_LOOKAHEAD_BITS are 4 and
-	* 3, respectively, break_even_point/8 will always be at least 1. */
-	if match_maxlen > (break_even_point / 8) {
-		log.Printf("-- best match: %v bytes at -%v\n",
+	/* min_match is break_even_point/8 + 1 by default, see options.go. */
+	if match_maxlen >= hse.min_match {
+		debug_log("-- best match: %v bytes at -%v\n",
 			match_maxlen, end-match_index)
 		return end - match_index, match_maxlen
 	}
-	log.Printf("-- none found\n")
+	debug_log("-- none found\n")
+	if match_maxlen > 0 && hse.stats != nil {
+		hse.stats.Rejected++
+	}
 	return MATCH_NOT_FOUND, 0
 }
 
-func push_outgoing_bits(hse *encoder) uint8 {
-	var count, bits uint8
-	if hse.outgoing_bits_count > 8 {
-		count = 8
-		bits = uint8(hse.outgoing_bits >> (hse.outgoing_bits_count - 8))
-	} else {
-		count = hse.outgoing_bits_count
-		bits = uint8(hse.outgoing_bits)
+/* Push the low COUNT (max 32) bits of VALUE to the output buffer, MSB
+* first. The bits are put together with those already in current_byte in
+* a 64-bit accumulator, and whole bytes written out at once; what is left
+* of a byte stays in current_byte, from the top down to bit_index. */
+func push_bits(hse *encoder, count uint8, value uint32) {
+	if count > 32 {
+		log.Fatal("Bit count assert failed.")
 	}
-	if count > 0 {
-		log.Printf("-- pushing %v outgoing bits: 0x%02x\n", count, bits)
-		push_bits(hse, count, bits)
-		hse.outgoing_bits_count -= count
+	debug_log("++ push_bits: %v bits, input of 0x%x\n", count, value)
+
+	pending := 8 - uint(bits.Len8(hse.bit_index)) /* bits in current_byte */
+	acc := uint64(hse.current_byte>>(8-pending))<<count | uint64(value)&(1<<count-1)
+	n := pending + uint(count)
+	var out [5]byte
+	k := 0
+	for ; n >= 8; k++ {
+		n -= 8
+		out[k] = byte(acc >> n)
 	}
-	return count
+	hse.outbuf.Write(out[:k])
+	hse.current_byte = byte(acc << (8 - n))
+	hse.bit_index = 0x80 >> n
 }
 
-/* Push COUNT (max 8) bits to the output buffer, which has room.
-* Bytes are set from the lowest bits, up. */
-func push_bits(hse *encoder, count, bits uint8) {
-	if count > 8 {
-		log.Fatal("Bit count assert failed.")
-	}
-	log.Printf("++ push_bits: %v bits, input of 0x%02x\n", count, bits)
+func literal_byte(hse *encoder) byte {
+	processed_offset := hse.match_scan_index - 1
+	input_offset := get_input_offset(hse) + processed_offset
+	c := buffer_at(hse, int(input_offset))
+	debug_log("-- yielded literal byte 0x%02x from +%v\n", c, input_offset)
+	return c
+}
 
-	/* If adding a whole byte and at the start of a new output byte,
-	* just push it through whole and skip the bit IO loop. */
-	if count == 8 && hse.bit_index == 0x80 {
-		hse.outbuf.WriteByte(bits)
-	} else {
-		for i := int(count) - 1; i >= 0; i-- {
-			if bits&(1<<uint(i)) != 0 {
-				hse.current_byte |= hse.bit_index
-			}
-			hse.bit_index >>= 1
-			if hse.bit_index == 0x00 {
-				hse.bit_index = 0x80
-				log.Printf(" > pushing byte 0x%02x\n", hse.current_byte)
-				hse.outbuf.WriteByte(hse.current_byte)
-				hse.current_byte = 0x00
-			}
-		}
+/* The buffer is circular: byte i (counting from the start of the
+* backlog) is at buffer[(head + i) & mask], so that saving the backlog
+* only moves head rather than copying the window down. Past the end of
+* the ring, buffer has a copy of its first lookahead size bytes, so that
+* a match (which is no longer than that) can be compared without
+* wrapping around. */
+func buffer_at(hse *encoder, i int) byte {
+	return hse.buffer[(hse.head+i)&(len(hse.search_index)-1)]
+}
+
+func buffer_write(hse *encoder, i int, data []byte) {
+	ring := hse.buffer[:len(hse.search_index)]
+	i = (hse.head + i) & (len(ring) - 1)
+	n := copy(ring[i:], data)
+	n += copy(ring, data[n:])
+	if lookahead_sz := int(get_lookahead_size(hse)); n > len(ring)-i || i < lookahead_sz {
+		buffer_mirror(hse)
 	}
 }
 
-func push_literal_byte(hse *encoder) {
-	processed_offset := hse.match_scan_index - 1
-	input_offset := get_input_offset(hse) + processed_offset
-	c := hse.buffer[input_offset]
-	log.Printf("-- yielded literal byte 0x%02x from +%v\n", c, input_offset)
-	push_bits(hse, 8, c)
+/* Update the copy of the start of the ring, after it was written to. */
+func buffer_mirror(hse *encoder) {
+	ring_sz := len(hse.search_index)
+	copy(hse.buffer[ring_sz:], hse.buffer[:len(hse.buffer)-ring_sz])
+}
+
+/* Append the buffer to b, starting with byte 0. */
+func append_buffer(b []byte, hse *encoder) []byte {
+	ring := hse.buffer[:len(hse.search_index)]
+	return append(append(b, ring[hse.head:]...), ring[:hse.head]...)
+}
+
+/* Position pos, after the start of the buffer moved on by shift. Those
+* that fall off the start become -1, for none (as is a byte that wasn't
+* seen yet), so that they don't run down to wrap around on a long
+* stream. Only whether they are before the start matters to indexing. */
+func backlog_pos(pos, shift int) int {
+	if pos-shift < -1 {
+		return -1
+	}
+	return pos - shift
 }
 
 func save_backlog(hse *encoder) {
 	input_buf_sz := get_input_buffer_size(hse)
 	msi := hse.match_scan_index
 
-	/* Copy processed data to beginning of buffer, so it can be
-	* used for future matches. Don't bother checking whether the
-	* input is less than the maximum size, because if it isn't,
-	* we're done anyway. */
+	/* Move the start of the buffer on, so that the processed data
+	* before it is kept for future matches. Don't bother checking
+	* whether the input is less than the maximum size, because if it
+	* isn't, we're done anyway. */
 	rem := input_buf_sz - msi // unprocessed bytes
 
-	copy(hse.buffer, hse.buffer[input_buf_sz-rem:])
+	shift := int(input_buf_sz - rem)
+	hse.head = (hse.head + shift) & (len(hse.search_index) - 1)
+	hse.indexed -= shift
+	hse.search_run = backlog_pos(hse.search_run, shift)
+	for i := range hse.search_last {
+		hse.search_last[i] = backlog_pos(hse.search_last[i], shift)
+	}
 
 	hse.match_scan_index = 0
 	hse.input_size -= input_buf_sz - rem
@@ -0,0 +1,298 @@
+package heatshrink
+
+import (
+	"math/bits"
+)
+
+/* Entropy coded heatshrink: the same literals and backrefs as plain
+* heatshrink, found by the same encoder, but coded with an adaptive binary
+* range coder (as in LZMA) rather than as fixed-size bit fields. Every
+* bit of a token is coded against a probability that adapts to the data
+* seen so far, so frequent tag bits, literal values, offsets and lengths
+* get cheaper. Decoding this takes far more work than plain heatshrink,
+* and it isn't something embedded decoders can read: it's meant for
+* transfers between hosts, in members with version MEMBER_VERSION_ENTROPY.
+*
+* Tokens are coded as follows:
+*
+*   tag         1 bit, in the context of the previous token's kind
+*   literal     8 bits, as a binary tree (each bit in the context of the
+*               bits above it)
+*   backref     offset - 1, then length - 1, each as a number:
+*   number      slot: the bit length of the value, as a 4 bit tree;
+*               then the bits below the leading 1, each in the context
+*               of its slot and position
+*
+* The coded data starts with a 0 byte (an artifact of the range coder's
+* carry handling) and has no end marker: the decoder is given the size
+* of the output. */
+
+const (
+	RC_PROB_BITS    = 11
+	RC_PROB_INIT    = 1 << (RC_PROB_BITS - 1) /* probability 1/2 */
+	RC_MOVE_BITS    = 5                       /* adaptation speed */
+	RC_TOP          = 1 << 24
+	RC_SLOT_BITS    = 4 /* values up to 2^15 - 1 have slots 0 to 15 */
+	RC_SLOTS        = 1 << RC_SLOT_BITS
+	RC_LITERAL_BITS = 8
+)
+
+/* Adaptive probabilities of 0 bits, out of 1 << RC_PROB_BITS. */
+type entropy_model struct {
+	tag     [2]uint16
+	literal [1 << RC_LITERAL_BITS]uint16
+	offset  number_model
+	length  number_model
+}
+
+type number_model struct {
+	slot  [RC_SLOTS]uint16
+	extra [RC_SLOTS][RC_SLOTS]uint16
+}
+
+func entropy_model_init(m *entropy_model) {
+	for _, p := range [][]uint16{m.tag[:], m.literal[:], m.offset.slot[:], m.length.slot[:]} {
+		for i := range p {
+			p[i] = RC_PROB_INIT
+		}
+	}
+	for i := range m.offset.extra {
+		for j := range m.offset.extra[i] {
+			m.offset.extra[i][j] = RC_PROB_INIT
+			m.length.extra[i][j] = RC_PROB_INIT
+		}
+	}
+}
+
+type entropy_encoder struct {
+	model      entropy_model
+	prev       uint8 /* kind of the previous token, 1 for a backref */
+	low        uint64
+	rng        uint32
+	cache      byte
+	cache_size int
+}
+
+func entropy_encoder_init(ee *entropy_encoder) {
+	entropy_model_init(&ee.model)
+	ee.prev = 0
+	ee.low = 0
+	ee.rng = 0xffffffff
+	ee.cache = 0
+	ee.cache_size = 1
+}
+
+/* Code the current token of hse, in place of pushing its bits. */
+func entropy_add_token(hse *encoder) {
+	ee := hse.entropy
+	m := &ee.model
+	if hse.match_length == 0 {
+		rc_encode_bit(hse, &m.tag[ee.prev], HEATSHRINK_LITERAL_MARKER)
+		rc_encode_tree(hse, m.literal[:], RC_LITERAL_BITS, uint32(literal_byte(hse)))
+		ee.prev = 0
+	} else {
+		rc_encode_bit(hse, &m.tag[ee.prev], HEATSHRINK_BACKREF_MARKER)
+		rc_encode_number(hse, &m.offset, uint32(hse.match_pos-1))
+		rc_encode_number(hse, &m.length, uint32(hse.match_length-1))
+		ee.prev = 1
+	}
+}
+
+/* Flush the range coder at the end of the data. */
+func entropy_finish(hse *encoder) {
+	for i := 0; i < 5; i++ {
+		rc_shift_low(hse)
+	}
+}
+
+func rc_encode_bit(hse *encoder, p *uint16, bit uint8) {
+	ee := hse.entropy
+	bound := (ee.rng >> RC_PROB_BITS) * uint32(*p)
+	if bit == 0 {
+		ee.rng = bound
+		*p += ((1 << RC_PROB_BITS) - *p) >> RC_MOVE_BITS
+	} else {
+		ee.low += uint64(bound)
+		ee.rng -= bound
+		*p -= *p >> RC_MOVE_BITS
+	}
+	for ee.rng < RC_TOP {
+		ee.rng <<= 8
+		rc_shift_low(hse)
+	}
+}
+
+/* Write out the top byte of low, holding back 0xff bytes (in cache and
+* cache_size) as long as a carry could still propagate into them. */
+func rc_shift_low(hse *encoder) {
+	ee := hse.entropy
+	if uint32(ee.low) < 0xff000000 || ee.low >= 1<<32 {
+		carry := byte(ee.low >> 32)
+		c := ee.cache
+		for ; ee.cache_size > 0; ee.cache_size-- {
+			hse.outbuf.WriteByte(c + carry)
+			c = 0xff
+		}
+		ee.cache = byte(ee.low >> 24)
+	}
+	ee.cache_size++
+	ee.low = (ee.low & 0x00ffffff) << 8
+}
+
+/* Code the low nbits of value MSB first, each bit in the context of the
+* ones before it: probs[1] for the first, probs[2 or 3] for the second,
+* and so on. */
+func rc_encode_tree(hse *encoder, probs []uint16, nbits uint, value uint32) {
+	node := uint32(1)
+	for i := int(nbits) - 1; i >= 0; i-- {
+		bit := uint8(value>>uint(i)) & 1
+		rc_encode_bit(hse, &probs[node], bit)
+		node = node<<1 | uint32(bit)
+	}
+}
+
+func rc_encode_number(hse *encoder, m *number_model, value uint32) {
+	slot := uint(bits.Len32(value))
+	rc_encode_tree(hse, m.slot[:], RC_SLOT_BITS, uint32(slot))
+	for i := int(slot) - 2; i >= 0; i-- {
+		rc_encode_bit(hse, &m.extra[slot][i], uint8(value>>uint(i))&1)
+	}
+}
+
+type entropy_decoder struct {
+	model entropy_model
+	rng   uint32
+	code  uint32
+	in    []byte
+	pos   int /* next byte of in, may run past the end */
+}
+
+func rc_next_byte(ed *entropy_decoder) uint32 {
+	var c byte
+	if ed.pos < len(ed.in) {
+		c = ed.in[ed.pos]
+	}
+	ed.pos++
+	return uint32(c)
+}
+
+func rc_decode_bit(ed *entropy_decoder, p *uint16) uint8 {
+	var bit uint8
+	bound := (ed.rng >> RC_PROB_BITS) * uint32(*p)
+	if ed.code < bound {
+		ed.rng = bound
+		*p += ((1 << RC_PROB_BITS) - *p) >> RC_MOVE_BITS
+	} else {
+		ed.code -= bound
+		ed.rng -= bound
+		*p -= *p >> RC_MOVE_BITS
+		bit = 1
+	}
+	if ed.rng < RC_TOP {
+		ed.rng <<= 8
+		ed.code = ed.code<<8 | rc_next_byte(ed)
+	}
+	return bit
+}
+
+func rc_decode_tree(ed *entropy_decoder, probs []uint16, nbits uint) uint32 {
+	node := uint32(1)
+	for i := uint(0); i < nbits; i++ {
+		node = node<<1 | uint32(rc_decode_bit(ed, &probs[node]))
+	}
+	return node - 1<<nbits
+}
+
+func rc_decode_number(ed *entropy_decoder, m *number_model) uint32 {
+	slot := rc_decode_tree(ed, m.slot[:], RC_SLOT_BITS)
+	if slot < 2 {
+		return slot
+	}
+	value := uint32(1)
+	for i := int(slot) - 2; i >= 0; i-- {
+		value = value<<1 | uint32(rc_decode_bit(ed, &m.extra[slot][i]))
+	}
+	return value
+}
+
+// CompressEntropy compresses data into entropy coded heatshrink, which
+// takes the same window and lookahead sizes as Compress, but is smaller,
+// and decodes only with DecompressEntropy (which needs to be told the
+// size of data). Of the options, only WithMinMatch and
+// WithStrictBreakEven apply: filters are left to CompressEntropyMember,
+// and the window always starts out zeroed. It is meant for transfers
+// between hosts, see CompressEntropyMember for a self-describing
+// container.
+func CompressEntropy(window, lookahead uint8, data []byte, opts ...Option) []byte {
+	if !valid_params(window, lookahead) {
+		return nil
+	}
+	hse := encoder_alloc(window, lookahead)
+	encoder_options(hse, opts)
+	hse.entropy = &entropy_encoder{}
+	entropy_encoder_init(hse.entropy)
+	compress(hse, data)
+	entropy_finish(hse)
+	return hse.outbuf.Bytes()
+}
+
+// DecompressEntropy decompresses data made by CompressEntropy, which was
+// size bytes before compression. It returns ErrData (in a DecodeError)
+// if data isn't valid entropy coded heatshrink of that size, and
+// ErrParams for invalid sizes, size included. As the
+// range decoder reads ahead, the error's offset is only approximate, and
+// its bit always 0.
+func DecompressEntropy(window, lookahead uint8, data []byte, size int) ([]byte, error) {
+	if !valid_params(window, lookahead) {
+		return nil, ErrParams
+	}
+	if size < 0 {
+		return nil, ErrParams
+	}
+	if len(data) == 0 || data[0] != 0 {
+		return nil, decode_error(ErrData, 0, HSDS_TAG_BIT, 0)
+	}
+	ed := &entropy_decoder{rng: 0xffffffff, in: data, pos: 1}
+	entropy_model_init(&ed.model)
+	for i := 0; i < 4; i++ {
+		ed.code = ed.code<<8 | rc_next_byte(ed)
+	}
+	m := &ed.model
+
+	/* size comes from the other end, don't trust it with memory */
+	capacity := size
+	if capacity > 1<<20 {
+		capacity = 1 << 20
+	}
+	out := make([]byte, 0, capacity)
+	prev := 0
+	for len(out) < size && ed.pos <= len(data) {
+		if rc_decode_bit(ed, &m.tag[prev]) == HEATSHRINK_LITERAL_MARKER {
+			out = append(out, byte(rc_decode_tree(ed, m.literal[:], RC_LITERAL_BITS)))
+			prev = 0
+			continue
+		}
+		index := int(rc_decode_number(ed, &m.offset)) + 1
+		count := int(rc_decode_number(ed, &m.length)) + 1
+		if index > 1<<window || count > 1<<lookahead || count > size-len(out) {
+			return nil, decode_error(ErrData, 8*entropy_offset(ed), HSDS_YIELD_BACKREF, len(out))
+		}
+		out = append_backref(out, 0, nil, index, count)
+		prev = 1
+	}
+	/* The coder reads 4 bytes ahead of what it has decoded, so it only
+	* runs out of input on the last few bits, if at all. */
+	if len(out) < size || ed.pos > len(data) {
+		return nil, decode_error(ErrData, 8*len(data), HSDS_TAG_BIT, len(out))
+	}
+	return out, nil
+}
+
+/* Roughly where in its input ed is: the byte holding the bits it is
+* decoding, 4 bytes behind the ones read. */
+func entropy_offset(ed *entropy_decoder) int {
+	if pos := ed.pos - 4; pos > 0 {
+		return pos
+	}
+	return 0
+}
@@ -0,0 +1,64 @@
+package heatshrink
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func TestEntropy(t *testing.T) {
+	data := test_corpus(8, 40000)
+	for w := uint8(HEATSHRINK_MIN_WINDOW_BITS); w <= HEATSHRINK_MAX_WINDOW_BITS; w++ {
+		for _, l := range []uint8{HEATSHRINK_MIN_LOOKAHEAD_BITS, w / 2, w - 1} {
+			if l < HEATSHRINK_MIN_LOOKAHEAD_BITS {
+				continue
+			}
+			comp := CompressEntropy(w, l, data)
+			if got, err := DecompressEntropy(w, l, comp, len(data)); err != nil || !bytes.Equal(got, data) {
+				t.Errorf("w%vl%v: round trip mismatch (%v)", w, l, err)
+			}
+			if len(comp) > len(Compress(w, l, data)) {
+				t.Errorf("w%vl%v: larger than Compress", w, l)
+			}
+		}
+	}
+	for _, n := range []int{0, 1, 2, 3} {
+		if got, err := DecompressEntropy(8, 4, CompressEntropy(8, 4, data[:n]), n); err != nil || !bytes.Equal(got, data[:n]) {
+			t.Errorf("%v bytes: round trip mismatch (%v)", n, err)
+		}
+	}
+}
+
+/* Entropy coded members mixed in with plain ones. */
+func TestEntropyMembers(t *testing.T) {
+	r := rand.New(rand.NewSource(7))
+	var members, want []byte
+	for i := 0; i < 8; i++ {
+		data := test_corpus(int64(i), r.Intn(5000))
+		if i%2 == 0 {
+			members = append(members, CompressMember(8, 4, data)...)
+		} else {
+			members = append(members, CompressEntropyMember(10, 5, data)...)
+		}
+		want = append(want, data...)
+	}
+	if got, err := DecompressMembers(members); err != nil || !bytes.Equal(got, want) {
+		t.Errorf("DecompressMembers: mismatch (%v)", err)
+	}
+}
+
+/* The match options apply, as documented; the window options don't. */
+func TestEntropyOptions(t *testing.T) {
+	data := test_corpus(3, 5000)
+	plain := CompressEntropy(8, 4, data)
+	if comp := CompressEntropy(8, 4, data, WithWindowFill(0xff)); !bytes.Equal(comp, plain) {
+		t.Errorf("WithWindowFill changed the output")
+	}
+	comp := CompressEntropy(8, 4, data, WithMinMatch(6))
+	if bytes.Equal(comp, plain) {
+		t.Errorf("WithMinMatch(6) made no difference")
+	}
+	if got, err := DecompressEntropy(8, 4, comp, len(data)); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("WithMinMatch(6): round trip mismatch (%v)", err)
+	}
+}
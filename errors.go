@@ -0,0 +1,62 @@
+package heatshrink
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrParams       = errors.New("heatshrink: invalid window or lookahead size")
+	ErrClosed       = errors.New("heatshrink: write to closed writer")
+	ErrState        = errors.New("heatshrink: invalid state snapshot")
+	ErrHeader       = errors.New("heatshrink: invalid member header")
+	ErrCorrupt      = errors.New("heatshrink: member size mismatch")
+	ErrShortInput   = errors.New("heatshrink: input ends before expected output size")
+	ErrTrailingData = errors.New("heatshrink: input goes on past expected output size")
+	ErrData         = errors.New("heatshrink: invalid compressed data")
+)
+
+// A DecodeError is what decoding returns when the compressed data is at
+// fault, saying where it went wrong. Err is one of the errors above, so
+// errors.Is(err, ErrShortInput) and the like tell what went wrong.
+type DecodeError struct {
+	Offset int    // byte of the compressed input
+	Bit    int    // bit of that byte, from 0 for the most significant
+	State  string // decoder state (HSDS_*), empty in member headers
+	Output int    // bytes output before that
+	Err    error
+}
+
+func (e *DecodeError) Error() string {
+	if e.State == "" {
+		return fmt.Sprintf("%v (at byte %v, after %v bytes of output)", e.Err, e.Offset, e.Output)
+	}
+	return fmt.Sprintf("%v (at byte %v bit %v, %v, after %v bytes of output)",
+		e.Err, e.Offset, e.Bit, e.State, e.Output)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+var hsds_names = [...]string{
+	HSDS_TAG_BIT:           "HSDS_TAG_BIT",
+	HSDS_YIELD_LITERAL:     "HSDS_YIELD_LITERAL",
+	HSDS_BACKREF_INDEX_MSB: "HSDS_BACKREF_INDEX_MSB",
+	HSDS_BACKREF_INDEX_LSB: "HSDS_BACKREF_INDEX_LSB",
+	HSDS_BACKREF_COUNT_MSB: "HSDS_BACKREF_COUNT_MSB",
+	HSDS_BACKREF_COUNT_LSB: "HSDS_BACKREF_COUNT_LSB",
+	HSDS_YIELD_BACKREF:     "HSDS_YIELD_BACKREF",
+}
+
+/* A DecodeError for err at bit pos of the input, in decoder state
+* (HSDS_*), after output bytes of output. */
+func decode_error(err error, pos int, state uint8, output int) *DecodeError {
+	return &DecodeError{
+		Offset: pos / 8,
+		Bit:    pos % 8,
+		State:  hsds_names[state],
+		Output: output,
+		Err:    err,
+	}
+}
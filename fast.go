@@ -0,0 +1,132 @@
+package heatshrink
+
+/* Fast path for decoding a stream that's all in memory at once.
+*
+* The state machine in decoder.go can stop between any two bits, which
+* is what streaming needs, but it costs a loop iteration per input bit
+* and a dispatch per token. With all the input at hand, decode_fast reads
+* whole tokens out of a 64-bit bit reservoir instead, and since all the
+* output is in one slice too, that slice doubles as the window: backrefs
+* are copied straight out of it. The output is the same as the state
+* machine's, down to incomplete tokens at the end being dropped, so the
+* state machine is only needed for partial input (Reader, Disassemble). */
+
+/* Decode src, appending the output to dst. dict is the preset window (as
+* for decoder_preset; nil for all zeros). If limit >= 0, decoding stops
+* once limit bytes are output, with the last backref cut short if need
+* be. Returns the output, the number of bits of src used (up to the end
+* of the last token decoded), and how many bytes the last backref was cut
+* short by. The window and lookahead sizes must be valid. */
+func decode_fast(window_sz2, lookahead_sz2 uint8, dict, src, dst []byte, limit int) (out []byte, nbits int, cut int) {
+	out = dst
+	base := len(dst)
+	wbits := uint(window_sz2)
+	lbits := uint(lookahead_sz2)
+	backref_bits := 1 + wbits + lbits
+
+	var acc uint64    /* bit reservoir, next bit in the MSB */
+	var acc_bits uint /* bits in acc */
+	in := 0           /* next byte of src to go into acc */
+	for {
+		for acc_bits <= 56 && in < len(src) {
+			acc |= uint64(src[in]) << (56 - acc_bits)
+			acc_bits += 8
+			in++
+		}
+		if acc_bits == 0 || (limit >= 0 && len(out)-base >= limit) {
+			break
+		}
+
+		if acc>>63 == 1 { /* literal */
+			if acc_bits < 9 {
+				break
+			}
+			out = append(out, byte(acc>>55))
+			acc <<= 9
+			acc_bits -= 9
+			continue
+		}
+
+		if acc_bits < backref_bits {
+			break
+		}
+		index := int(acc<<1>>(64-wbits)) + 1
+		count := int(acc<<(1+wbits)>>(64-lbits)) + 1
+		acc <<= backref_bits
+		acc_bits -= backref_bits
+
+		if index == 1 && count == 1 { /* sync marker, skip the padding */
+			pad := acc_bits % 8
+			acc <<= pad
+			acc_bits -= pad
+			continue
+		}
+
+		if pos := len(out) - base; limit >= 0 && count > limit-pos {
+			cut = count - (limit - pos)
+			count -= cut
+		}
+		out = append_backref(out, base, dict, index, count)
+	}
+	return out, 8*in - int(acc_bits), cut
+}
+
+/* Append count bytes from index bytes back to out, whose output starts
+* at out[base], with dict as the preset window. */
+func append_backref(out []byte, base int, dict []byte, index, count int) []byte {
+	/* Bytes from before the start of the output come from the
+	* preset window. */
+	for pos := len(out) - base; index > pos && count > 0; pos++ {
+		var c byte
+		if i := len(dict) - (index - pos); i >= 0 {
+			c = dict[i]
+		}
+		out = append(out, c)
+		count--
+	}
+
+	/* Copy the rest out of the output. When the backref overlaps
+	* the bytes it outputs, they repeat with a period of index, so
+	* copy what's there and double up until done. */
+	from := len(out) - index
+	for count > 0 {
+		n := len(out) - from
+		if n > count {
+			n = count
+		}
+		out = append(out, out[from:from+n]...)
+		count -= n
+	}
+	return out
+}
+
+func valid_params(window_sz2, lookahead_sz2 uint8) bool {
+	return window_sz2 >= HEATSHRINK_MIN_WINDOW_BITS &&
+		window_sz2 <= HEATSHRINK_MAX_WINDOW_BITS &&
+		lookahead_sz2 >= HEATSHRINK_MIN_LOOKAHEAD_BITS &&
+		lookahead_sz2 < window_sz2
+}
+
+/* The state the state machine would be left in by the bits of src from
+* nbits on, when they don't make up a whole token. */
+func partial_state(window_sz2, lookahead_sz2 uint8, src []byte, nbits int) uint8 {
+	left := 8*len(src) - nbits
+	switch {
+	case left <= 0:
+		return HSDS_TAG_BIT
+	case src[nbits/8]&(0x80>>uint(nbits%8)) != 0:
+		return HSDS_YIELD_LITERAL
+	}
+	left-- /* tag bit */
+	if left < int(window_sz2) {
+		if window_sz2 > 8 && left < int(window_sz2)-8 {
+			return HSDS_BACKREF_INDEX_MSB
+		}
+		return HSDS_BACKREF_INDEX_LSB
+	}
+	left -= int(window_sz2)
+	if lookahead_sz2 > 8 && left < int(lookahead_sz2)-8 {
+		return HSDS_BACKREF_COUNT_MSB
+	}
+	return HSDS_BACKREF_COUNT_LSB
+}
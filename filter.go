@@ -0,0 +1,158 @@
+package heatshrink
+
+/* Filters: reversible transforms of the data before compression, which
+* turn content with a known structure into something with more repeats
+* in it.
+*
+* The branch filters (as in xz's BCJ filters) convert the relative
+* target offsets of branch-and-link instructions in ARM code to absolute
+* addresses. Calls to the same function from different places then have
+* the same bytes, and can be backrefs. Positions count from the start of
+* the data, wherever it gets loaded.
+*
+* The delta filter replaces each byte with its difference from the byte
+* stride bytes before. Slowly changing samples (say, int16 with a stride
+* of 2, or interleaved channels with a stride of channels * 2) turn into
+* small differences, that repeat far more than the samples do.
+*
+* The filter of a member is in the flags byte of its header:
+*
+*   bits 0-1    FILTER_NONE, FILTER_ARM, FILTER_THUMB or FILTER_DELTA
+*   bits 2-7    stride - 1 for FILTER_DELTA, 0 otherwise */
+
+const (
+	FILTER_NONE       = 0
+	FILTER_ARM        = 1 /* BL instructions of 32-bit ARM code */
+	FILTER_THUMB      = 2 /* BL instruction pairs of Thumb code */
+	FILTER_DELTA      = 3 /* differences of bytes stride bytes apart */
+	FILTER_MAX_STRIDE = 64
+)
+
+// WithARMFilter converts the branch targets of 32-bit ARM code before
+// compressing it, and back after decompressing it. See WithDeltaFilter
+// for where filters apply.
+func WithARMFilter() Option {
+	return func(o *options) {
+		o.filter, o.stride = FILTER_ARM, 0
+	}
+}
+
+// WithThumbFilter converts the branch targets of ARM Thumb code (as in
+// Cortex-M firmware) before compressing it, and back after decompressing
+// it. See WithDeltaFilter for where filters apply.
+func WithThumbFilter() Option {
+	return func(o *options) {
+		o.filter, o.stride = FILTER_THUMB, 0
+	}
+}
+
+// WithDeltaFilter replaces each byte with its difference from the byte
+// stride bytes before (stride is 1 to FILTER_MAX_STRIDE) before
+// compressing data, and undoes that after decompressing it. This suits
+// arrays of samples: a stride of 2 for little-endian int16 samples, for
+// instance.
+//
+// Filters apply to Compress, CompressWithStats and Decompress (which
+// needs the same filter), and to the member functions, which record the
+// filter in the member header for MemberReader to undo. The other
+// functions ignore them. Only the last filter given applies.
+func WithDeltaFilter(stride int) Option {
+	return func(o *options) {
+		o.filter, o.stride = FILTER_DELTA, stride
+	}
+}
+
+/* The member header flags for the filter of o; ok is false if its stride
+* is out of range. */
+func filter_flags(o *options) (flags uint8, ok bool) {
+	if o.filter != FILTER_DELTA {
+		return o.filter, true
+	}
+	if o.stride < 1 || o.stride > FILTER_MAX_STRIDE {
+		return 0, false
+	}
+	return uint8(o.stride-1)<<2 | FILTER_DELTA, true
+}
+
+func valid_filter_flags(flags uint8) bool {
+	return flags&3 == FILTER_DELTA || flags>>2 == 0
+}
+
+/* Data run through the filter in flags, in a copy if there is one. */
+func filter_encode(flags uint8, data []byte) []byte {
+	if flags == FILTER_NONE {
+		return data
+	}
+	out := append([]byte(nil), data...)
+	switch flags & 3 {
+	case FILTER_ARM:
+		bcj_arm(out, true)
+	case FILTER_THUMB:
+		bcj_thumb(out, true)
+	case FILTER_DELTA:
+		stride := int(flags>>2) + 1
+		for i := len(out) - 1; i >= stride; i-- {
+			out[i] -= out[i-stride]
+		}
+	}
+	return out
+}
+
+/* Undo the filter in flags on data, in place. */
+func filter_decode(flags uint8, data []byte) {
+	switch flags & 3 {
+	case FILTER_ARM:
+		bcj_arm(data, false)
+	case FILTER_THUMB:
+		bcj_thumb(data, false)
+	case FILTER_DELTA:
+		stride := int(flags>>2) + 1
+		for i := stride; i < len(data); i++ {
+			data[i] += data[i-stride]
+		}
+	}
+}
+
+/* BL: a word with 0xeb in the top byte, and a 24 bit word offset from
+* the instruction's address + 8 in the others (little-endian). */
+func bcj_arm(data []byte, encode bool) {
+	for i := 0; i+4 <= len(data); i += 4 {
+		if data[i+3] != 0xeb {
+			continue
+		}
+		v := uint32(data[i+2])<<16 | uint32(data[i+1])<<8 | uint32(data[i])
+		v <<= 2
+		if encode {
+			v += uint32(i + 8)
+		} else {
+			v -= uint32(i + 8)
+		}
+		v >>= 2
+		data[i+2], data[i+1], data[i] = byte(v>>16), byte(v>>8), byte(v)
+	}
+}
+
+/* BL: two halfwords, 11110 and the high 11 bits of a 22 bit halfword
+* offset from the instruction's address + 4, then 11111 and the low 11
+* bits (each halfword little-endian). */
+func bcj_thumb(data []byte, encode bool) {
+	for i := 0; i+4 <= len(data); i += 2 {
+		if data[i+1]&0xf8 != 0xf0 || data[i+3]&0xf8 != 0xf8 {
+			continue
+		}
+		v := uint32(data[i+1]&7)<<19 | uint32(data[i])<<11 |
+			uint32(data[i+3]&7)<<8 | uint32(data[i+2])
+		v <<= 1
+		if encode {
+			v += uint32(i + 4)
+		} else {
+			v -= uint32(i + 4)
+		}
+		v >>= 1
+		data[i+1] = 0xf0 | byte(v>>19)&7
+		data[i] = byte(v >> 11)
+		data[i+3] = 0xf8 | byte(v>>8)&7
+		data[i+2] = byte(v)
+		i += 2 /* skip the second halfword */
+	}
+}
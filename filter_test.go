@@ -0,0 +1,80 @@
+package heatshrink
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+	"testing/iotest"
+)
+
+/* Thumb code: BL pairs calling a few functions from all over, among
+* other halfwords. */
+func thumb_corpus(r *rand.Rand, size int) []byte {
+	targets := []int{0x1000, 0x2468, 0x8000, 0x9abc}
+	b := make([]byte, 0, size+4)
+	for len(b) < size {
+		if r.Intn(3) == 0 {
+			off := (targets[r.Intn(len(targets))] - len(b) - 4) >> 1
+			b = binary.LittleEndian.AppendUint16(b, uint16(0xf000|(off>>11)&0x7ff))
+			b = binary.LittleEndian.AppendUint16(b, uint16(0xf800|off&0x7ff))
+		} else {
+			b = binary.LittleEndian.AppendUint16(b, uint16(r.Intn(64)))
+		}
+	}
+	return b[:size]
+}
+
+func TestFilters(t *testing.T) {
+	r := rand.New(rand.NewSource(6))
+	data := thumb_corpus(r, 10001) /* odd, to leave a partial word */
+	for _, opt := range []Option{WithARMFilter(), WithThumbFilter(),
+		WithDeltaFilter(1), WithDeltaFilter(2), WithDeltaFilter(FILTER_MAX_STRIDE)} {
+		comp := Compress(8, 4, data, opt)
+		if got := Decompress(8, 4, comp, opt); !bytes.Equal(got, data) {
+			t.Errorf("%v: round trip mismatch", get_options([]Option{opt}))
+		}
+		if got := Decompress(8, 4, comp); bytes.Equal(got, data) &&
+			get_options([]Option{opt}).filter != FILTER_ARM { /* no ARM code in data */
+			t.Errorf("%v: data went through unchanged", get_options([]Option{opt}))
+		}
+	}
+	if len(Compress(8, 4, data, WithThumbFilter())) >= len(Compress(8, 4, data)) {
+		t.Errorf("Thumb filter doesn't help on Thumb code")
+	}
+	if Compress(8, 4, data, WithDeltaFilter(0)) != nil {
+		t.Errorf("delta stride 0 accepted")
+	}
+}
+
+/* Members record their filter: filtered ones of every kind, among
+* unfiltered ones, read back as one stream. */
+func TestFilteredMembers(t *testing.T) {
+	r := rand.New(rand.NewSource(7))
+	var members, want []byte
+	for i := 0; i < 12; i++ {
+		data := test_corpus(int64(i), r.Intn(5000))
+		var opts []Option
+		if i%4 == 3 {
+			data = thumb_corpus(r, len(data))
+			opts = append(opts, WithThumbFilter())
+		}
+		switch i % 3 {
+		case 0:
+			members = append(members, CompressMember(8, 4, data, opts...)...)
+		case 1:
+			members = append(members, CompressEntropyMember(10, 5, data, opts...)...)
+		case 2:
+			members = append(members, CompressVariableMember(17, 8, data, opts...)...)
+		}
+		want = append(want, data...)
+	}
+	if got, err := DecompressMembers(members); err != nil || !bytes.Equal(got, want) {
+		t.Errorf("DecompressMembers: mismatch (%v)", err)
+	}
+	zr := NewMemberReader(iotest.OneByteReader(bytes.NewReader(members)))
+	if got, err := ioutil.ReadAll(iotest.OneByteReader(zr)); err != nil || !bytes.Equal(got, want) {
+		t.Errorf("MemberReader: mismatch (%v)", err)
+	}
+}
@@ -0,0 +1,36 @@
+package heatshrink
+
+/* Data read back from flash is usually followed by erased flash, 0xFF,
+* up to the end of a page or sector. To the decoder that's just more
+* input: tag bit 1 and 8 more 1 bits make a literal 0xFF, so decoding it
+* adds junk to the output. */
+
+// DecompressFlash decompresses data that may be followed by erased flash
+// or other garbage, and reports how many bytes of data the stream took.
+//
+// If the uncompressed size is known, pass it as size: decoding stops as
+// soon as that many bytes are output, whatever follows. Otherwise pass a
+// negative size, and trailing 0xFF bytes are taken as erased flash and
+// ignored. Note that a stream can legitimately end in a 0xFF byte, when
+// its last tokens are literal 0xFFs ending on a byte boundary; those are
+// lost without a size.
+//
+// It returns ErrShortInput if data ends before size bytes are output,
+// and ErrTrailingData if the last backref runs past size bytes (see
+// DecompressPrefix).
+func DecompressFlash(window, lookahead uint8, data []byte, size int, opts ...Option) ([]byte, int, error) {
+	if size >= 0 {
+		out, nbits, err := DecompressPrefix(window, lookahead, data, size, opts...)
+		return out, (nbits + 7) / 8, err
+	}
+	n := len(data)
+	for n > 0 && data[n-1] == 0xff {
+		n--
+	}
+	if !valid_params(window, lookahead) {
+		return nil, 0, ErrParams
+	}
+	dict := preset_dict(get_options(opts), window, nil)
+	out, _, _ := decode_fast(window, lookahead, dict, data[:n], nil, -1)
+	return out, n, nil
+}
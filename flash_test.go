@@ -0,0 +1,71 @@
+package heatshrink
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestDecompressFlash(t *testing.T) {
+	data := test_corpus(14, 3000)
+	comp := Compress(8, 4, data)
+	if comp[len(comp)-1] == 0xff {
+		t.Fatalf("stream ends in 0xff, pick another corpus")
+	}
+	erased := append(append([]byte{}, comp...), bytes.Repeat([]byte{0xff}, 300)...)
+
+	/* Size known: whatever follows is left alone. */
+	for _, src := range [][]byte{comp, erased, append(append([]byte{}, comp...), "junk"...)} {
+		out, n, err := DecompressFlash(8, 4, src, len(data))
+		if err != nil || !bytes.Equal(out, data) || n != len(comp) {
+			t.Errorf("size %v: got %v bytes in %v (%v), want %v in %v",
+				len(data), len(out), n, err, len(data), len(comp))
+		}
+	}
+
+	/* Size unknown: erased flash is dropped. */
+	for _, src := range [][]byte{comp, erased} {
+		out, n, err := DecompressFlash(8, 4, src, -1)
+		if err != nil || !bytes.Equal(out, data) || n != len(comp) {
+			t.Errorf("no size: got %v bytes in %v (%v), want %v in %v",
+				len(out), n, err, len(data), len(comp))
+		}
+	}
+
+	opt := WithWindowFill(0xff)
+	comp = Compress(8, 4, data, opt)
+	if out, _, err := DecompressFlash(8, 4, comp, len(data), opt); err != nil || !bytes.Equal(out, data) {
+		t.Errorf("with a window fill: mismatch (%v)", err)
+	}
+}
+
+func TestDecompressFlashCorrupt(t *testing.T) {
+	data := []byte("abcabcabcabc")
+	comp := Compress(8, 4, data)
+	erased := append(append([]byte{}, comp...), 0xff, 0xff, 0xff)
+
+	/* Erased flash decodes as literal 0xFFs, so a size past the end of
+	* the stream gets those, and then runs out. */
+	_, _, err := DecompressFlash(8, 4, erased, 100)
+	if !errors.Is(err, ErrShortInput) {
+		t.Errorf("size past the end: %v, want ErrShortInput", err)
+	}
+
+	/* "abc", then a backref of 9 bytes: cutting it short is an error. */
+	out, _, err := DecompressFlash(8, 4, erased, 5)
+	var de *DecodeError
+	if !errors.Is(err, ErrTrailingData) || !errors.As(err, &de) || string(out) != "abcab" {
+		t.Errorf("size 5: got %q, %v; want ErrTrailingData", out, err)
+	}
+
+	for _, size := range []int{-1, len(data)} {
+		if _, _, err := DecompressFlash(8, 8, comp, size); !errors.Is(err, ErrParams) {
+			t.Errorf("size %v, lookahead 8: %v, want ErrParams", size, err)
+		}
+	}
+
+	/* All erased: nothing there. */
+	if out, n, err := DecompressFlash(8, 4, bytes.Repeat([]byte{0xff}, 64), -1); err != nil || len(out) != 0 || n != 0 {
+		t.Errorf("erased flash only: got %v bytes in %v (%v), want nothing", len(out), n, err)
+	}
+}
@@ -0,0 +1,3 @@
+module github.com/whowechina/heatshrink
+
+go 1.20
@@ -0,0 +1,135 @@
+// Package hsdelta makes patches that turn an old version of a file, such
+// as a firmware image, into a new one, for devices that already have the
+// old version (in flash, say) and a heatshrink decoder.
+//
+// A patch is plain heatshrink data compressed against a preset window
+// taken from the old version, so that backrefs can point into it. As a
+// window only reaches 2^window bytes back, the new version is cut into
+// blocks, and each block is compressed on its own against the part of
+// the old version around the same offset; with blocks shorter than the
+// window, content that merely moved by a few bytes stays in reach.
+//
+// Patch layout (multi-byte fields are little-endian):
+//
+//	magic      "HSP"
+//	version    1
+//	window     window size in bits
+//	lookahead  lookahead size in bits
+//	block      4 bytes: block size, 0 for a single block
+//	size       4 bytes: size of the new version
+//	then for each block:
+//	length     4 bytes: size of the compressed block
+//	data       heatshrink stream, decoded with a preset window
+//
+// The preset window of the block starting at offset s of the new version
+// is the 2^window bytes of the old version starting at s - (2^window -
+// block)/2 (or s, if the block isn't shorter than the window), where
+// bytes outside the old version are 0.
+package hsdelta
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/whowechina/heatshrink"
+)
+
+const (
+	magic   = "HSP"
+	version = 1
+)
+
+var ErrPatch = errors.New("hsdelta: invalid patch")
+
+// Diff returns a patch that turns old into new. With a block size of 0
+// all of new is a single heatshrink stream, whose preset window is the
+// start of old; otherwise new is compressed in blocks of that many
+// bytes. Half the window size is a good block size for firmware images.
+func Diff(window, lookahead uint8, old, new []byte, block int) ([]byte, error) {
+	if window < heatshrink.HEATSHRINK_MIN_WINDOW_BITS ||
+		window > heatshrink.HEATSHRINK_MAX_WINDOW_BITS ||
+		lookahead < heatshrink.HEATSHRINK_MIN_LOOKAHEAD_BITS ||
+		lookahead >= window {
+		return nil, heatshrink.ErrParams
+	}
+	if block < 0 {
+		return nil, errors.New("hsdelta: negative block size")
+	}
+	patch := append([]byte(magic), version, window, lookahead)
+	patch = binary.LittleEndian.AppendUint32(patch, uint32(block))
+	patch = binary.LittleEndian.AppendUint32(patch, uint32(len(new)))
+	step := block
+	if step == 0 {
+		step = len(new)
+	}
+	for start := 0; ; {
+		end := start + step
+		if end > len(new) {
+			end = len(new)
+		}
+		dict := reference(window, block, old, start)
+		data := heatshrink.CompressWithDict(window, lookahead, dict, new[start:end])
+		patch = binary.LittleEndian.AppendUint32(patch, uint32(len(data)))
+		patch = append(patch, data...)
+		if start = end; start >= len(new) {
+			break
+		}
+	}
+	return patch, nil
+}
+
+// Patch applies a patch made by Diff to old, returning the new version.
+func Patch(old, patch []byte) ([]byte, error) {
+	if len(patch) < 14 || string(patch[:3]) != magic || patch[3] != version {
+		return nil, ErrPatch
+	}
+	window, lookahead := patch[4], patch[5]
+	block := int(binary.LittleEndian.Uint32(patch[6:]))
+	size := int(binary.LittleEndian.Uint32(patch[10:]))
+	patch = patch[14:]
+	if window < heatshrink.HEATSHRINK_MIN_WINDOW_BITS ||
+		window > heatshrink.HEATSHRINK_MAX_WINDOW_BITS ||
+		lookahead < heatshrink.HEATSHRINK_MIN_LOOKAHEAD_BITS ||
+		lookahead >= window || block < 0 {
+		return nil, ErrPatch
+	}
+
+	var out []byte
+	for len(patch) > 0 {
+		if len(patch) < 4 {
+			return nil, ErrPatch
+		}
+		n := binary.LittleEndian.Uint32(patch)
+		patch = patch[4:]
+		if uint64(n) > uint64(len(patch)) {
+			return nil, ErrPatch
+		}
+		dict := reference(window, block, old, len(out))
+		data := heatshrink.DecompressWithDict(window, lookahead, dict, patch[:n])
+		patch = patch[n:]
+		if block > 0 && len(data) != block && len(patch) > 0 {
+			return nil, ErrPatch /* only the last block can be short */
+		}
+		out = append(out, data...)
+	}
+	if len(out) != size {
+		return nil, ErrPatch
+	}
+	return out, nil
+}
+
+/* Return the preset window for the block starting at start. */
+func reference(window uint8, block int, old []byte, start int) []byte {
+	w := 1 << window
+	ref := start
+	if block > 0 && block < w {
+		ref -= (w - block) / 2
+	}
+	dict := make([]byte, w)
+	for i := range dict {
+		if j := ref + i; j >= 0 && j < len(old) {
+			dict[i] = old[j]
+		}
+	}
+	return dict
+}
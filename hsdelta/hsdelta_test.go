@@ -0,0 +1,113 @@
+package hsdelta
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"testing"
+
+	"github.com/whowechina/heatshrink"
+)
+
+/* An old firmware image, and a new one with a few bytes patched, a run
+* inserted (so everything after it moved) and a bit cut off the end. */
+func versions() (old, new []byte) {
+	r := rand.New(rand.NewSource(7))
+	old = make([]byte, 20000)
+	r.Read(old)
+	new = append([]byte{}, old[:5000]...)
+	new[1234] ^= 0xff
+	new = append(new, "inserted by the new version"...)
+	new = append(new, old[5000:19000]...)
+	return old, new
+}
+
+func TestRoundTrip(t *testing.T) {
+	old, new := versions()
+	for _, c := range []struct {
+		w, l  uint8
+		block int
+	}{{8, 4, 0}, {8, 4, 128}, {10, 5, 512}, {10, 5, 1024}, {10, 5, 3000}, {12, 6, 2048}} {
+		patch, err := Diff(c.w, c.l, old, new, c.block)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := Patch(old, patch)
+		if err != nil || !bytes.Equal(got, new) {
+			t.Errorf("w%vl%v block %v: patch gave %v bytes (%v), want the new version",
+				c.w, c.l, c.block, len(got), err)
+		}
+	}
+
+	/* Empty versions, either way. */
+	for _, p := range [][2][]byte{{nil, nil}, {old, nil}, {nil, old[:300]}} {
+		patch, _ := Diff(8, 4, p[0], p[1], 64)
+		if got, err := Patch(p[0], patch); err != nil || !bytes.Equal(got, p[1]) {
+			t.Errorf("%v -> %v bytes: got %v bytes (%v)", len(p[0]), len(p[1]), len(got), err)
+		}
+	}
+}
+
+/* Blocks shorter than the window keep the old version around the same
+* offset in reach, even after the insertion moved it: the patch must come
+* out much smaller than the new version compressed on its own. */
+func TestBlocks(t *testing.T) {
+	old, new := versions()
+	plain := len(heatshrink.Compress(10, 5, new))
+	single, _ := Diff(10, 5, old, new, 0)
+	blocked, _ := Diff(10, 5, old, new, 512)
+	if len(blocked) >= plain/4 {
+		t.Errorf("patch with 512 byte blocks is %v bytes, want well under %v/4", len(blocked), plain)
+	}
+	if len(blocked) >= len(single) {
+		t.Errorf("patch with blocks (%v bytes) no smaller than a single block (%v)", len(blocked), len(single))
+	}
+}
+
+/* A patch only holds the differences, and doesn't identify its base:
+* applied to the wrong one, it makes something else than the new version. */
+func TestWrongBase(t *testing.T) {
+	old, new := versions()
+	patch, _ := Diff(10, 5, old, new, 512)
+	other := append([]byte{}, old...)
+	for i := range other {
+		other[i] ^= 0x5a
+	}
+	got, err := Patch(other, patch)
+	if err != nil || len(got) != len(new) {
+		t.Fatalf("Patch(wrong base): %v bytes (%v), want %v", len(got), err, len(new))
+	}
+	if bytes.Equal(got, new) {
+		t.Errorf("Patch(wrong base) made the new version")
+	}
+}
+
+func TestBadPatches(t *testing.T) {
+	old, new := versions()
+	patch, _ := Diff(8, 4, old, new, 128)
+
+	bad := func(name string, p []byte) {
+		if _, err := Patch(old, p); err != ErrPatch {
+			t.Errorf("%v: %v, want ErrPatch", name, err)
+		}
+	}
+	bad("empty", nil)
+	bad("header only", patch[:13])
+	bad("truncated", patch[:len(patch)-1])
+	bad("block length cut short", patch[:16])
+	for i, v := range map[int]byte{0: 'X', 3: 2, 4: 3, 5: 8} {
+		p := append([]byte{}, patch...)
+		p[i] = v
+		bad("corrupt header", p)
+	}
+	p := append([]byte{}, patch...)
+	p[10]++ /* size */
+	bad("wrong size", p)
+
+	if _, err := Diff(8, 8, old, new, 0); !errors.Is(err, heatshrink.ErrParams) {
+		t.Errorf("Diff(8, 8): %v, want ErrParams", err)
+	}
+	if _, err := Diff(8, 4, old, new, -1); err == nil {
+		t.Errorf("Diff(block -1) succeeded")
+	}
+}
@@ -0,0 +1,279 @@
+// Package hsframe sends heatshrink compressed messages over byte streams
+// without packet boundaries, such as UARTs.
+//
+// Each message is compressed on its own and sent as one frame:
+//
+//	length  2 bytes, little-endian: uncompressed size of the message
+//	data    heatshrink compressed message
+//	crc     2 bytes, little-endian: CRC-16/CCITT-FALSE of length and data
+//
+// which is then encoded with COBS or SLIP, and enclosed in delimiters
+// (0x00 bytes for COBS, END bytes for SLIP). Since the delimiter never
+// appears inside a frame, a reader can always pick up again at the next
+// frame after line noise or a lost byte, and the leading delimiter keeps
+// noise sent while the line was idle out of the frame that follows.
+package hsframe
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/whowechina/heatshrink"
+)
+
+// Framing selects how frames are delimited.
+type Framing uint8
+
+const (
+	COBS Framing = iota /* consistent overhead byte stuffing, 0x00 delimited */
+	SLIP                /* RFC 1055 */
+)
+
+const (
+	MaxMessage = 0xffff /* largest message size the length field can carry */
+
+	slip_end     = 0xc0
+	slip_esc     = 0xdb
+	slip_esc_end = 0xdc
+	slip_esc_esc = 0xdd
+)
+
+var ErrTooLong = errors.New("hsframe: message too long")
+
+// Writer sends messages as frames to an underlying io.Writer.
+type Writer struct {
+	w                 io.Writer
+	framing           Framing
+	window, lookahead uint8
+}
+
+// NewWriter returns a Writer compressing messages with the given window
+// and lookahead sizes, or heatshrink.ErrParams if they are invalid.
+func NewWriter(w io.Writer, framing Framing, window, lookahead uint8) (*Writer, error) {
+	if !valid_params(window, lookahead) {
+		return nil, heatshrink.ErrParams
+	}
+	return &Writer{w: w, framing: framing, window: window, lookahead: lookahead}, nil
+}
+
+// WriteMessage compresses msg and writes it out as a single frame.
+func (fw *Writer) WriteMessage(msg []byte) error {
+	if len(msg) > MaxMessage {
+		return ErrTooLong
+	}
+	frame := binary.LittleEndian.AppendUint16(nil, uint16(len(msg)))
+	frame = append(frame, heatshrink.Compress(fw.window, fw.lookahead, msg)...)
+	frame = binary.LittleEndian.AppendUint16(frame, crc16(frame))
+
+	var out []byte
+	if fw.framing == SLIP {
+		out = slip_encode(frame)
+	} else {
+		out = cobs_encode(frame)
+	}
+	_, err := fw.w.Write(out)
+	return err
+}
+
+// Reader receives framed messages from an underlying io.Reader.
+type Reader struct {
+	r                 *bufio.Reader
+	framing           Framing
+	window, lookahead uint8
+
+	/* Dropped counts the frames skipped because they were damaged. */
+	Dropped int
+}
+
+// NewReader returns a Reader decompressing messages with the given window
+// and lookahead sizes, or heatshrink.ErrParams if they are invalid.
+func NewReader(r io.Reader, framing Framing, window, lookahead uint8) (*Reader, error) {
+	if !valid_params(window, lookahead) {
+		return nil, heatshrink.ErrParams
+	}
+	return &Reader{r: bufio.NewReader(r), framing: framing, window: window, lookahead: lookahead}, nil
+}
+
+func valid_params(window, lookahead uint8) bool {
+	return window >= heatshrink.HEATSHRINK_MIN_WINDOW_BITS &&
+		window <= heatshrink.HEATSHRINK_MAX_WINDOW_BITS &&
+		lookahead >= heatshrink.HEATSHRINK_MIN_LOOKAHEAD_BITS &&
+		lookahead < window
+}
+
+// ReadMessage returns the next message received intact. Damaged frames
+// are skipped (and counted in Dropped); errors are those of the
+// underlying reader.
+func (fr *Reader) ReadMessage() ([]byte, error) {
+	for {
+		raw, ok, err := fr.read_frame()
+		if err != nil {
+			return nil, err
+		}
+		if ok && len(raw) == 0 {
+			continue /* back to back delimiters */
+		}
+		if ok {
+			var msg []byte
+			if msg, ok = fr.unpack(raw); ok {
+				return msg, nil
+			}
+		}
+		fr.Dropped++
+	}
+}
+
+/* Read up to (and not including) the next delimiter. Frames too long
+* to be valid are discarded as they come in, and reported as not ok. */
+func (fr *Reader) read_frame() ([]byte, bool, error) {
+	delim := byte(0x00)
+	if fr.framing == SLIP {
+		delim = slip_end
+	}
+	/* Worst case COBS or SLIP encoding of the largest message that
+	* could make it through (stored literals, 9 bits per byte). */
+	max := 2 * (4 + MaxMessage*9/8 + 1)
+	var frame []byte
+	overflow := false
+	for {
+		chunk, err := fr.r.ReadSlice(delim)
+		if len(frame)+len(chunk) > max {
+			overflow = true
+			frame = frame[:0]
+		}
+		if !overflow {
+			frame = append(frame, chunk...)
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		if overflow {
+			return nil, false, nil
+		}
+		return frame[:len(frame)-1], true, nil
+	}
+}
+
+func (fr *Reader) unpack(raw []byte) ([]byte, bool) {
+	var frame []byte
+	var ok bool
+	if fr.framing == SLIP {
+		frame, ok = slip_decode(raw)
+	} else {
+		frame, ok = cobs_decode(raw)
+	}
+	if !ok || len(frame) < 4 {
+		return nil, false
+	}
+	body := frame[:len(frame)-2]
+	if crc16(body) != binary.LittleEndian.Uint16(frame[len(frame)-2:]) {
+		return nil, false
+	}
+	size := int(binary.LittleEndian.Uint16(body))
+	msg := heatshrink.Decompress(fr.window, fr.lookahead, body[2:])
+	if len(msg) != size {
+		return nil, false
+	}
+	return msg, true
+}
+
+/* CRC-16/CCITT-FALSE: polynomial 0x1021, initial value 0xffff. Bitwise,
+* as that's what the other end most likely runs too. */
+func crc16(data []byte) uint16 {
+	crc := uint16(0xffff)
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+/* COBS encode data, enclosed in 0x00 delimiters. */
+func cobs_encode(data []byte) []byte {
+	out := make([]byte, 2, len(data)+len(data)/254+3)
+	code_pos := 1
+	code := byte(1)
+	for _, b := range data {
+		if b != 0 {
+			out = append(out, b)
+			code++
+		}
+		if b == 0 || code == 0xff {
+			out[code_pos] = code
+			code_pos = len(out)
+			out = append(out, 0)
+			code = 1
+		}
+	}
+	out[code_pos] = code
+	return append(out, 0x00)
+}
+
+/* COBS decode a frame without its delimiter. */
+func cobs_decode(data []byte) ([]byte, bool) {
+	out := make([]byte, 0, len(data))
+	for i := 0; i < len(data); {
+		code := int(data[i])
+		if code == 0 || i+code > len(data) {
+			return nil, false
+		}
+		out = append(out, data[i+1:i+code]...)
+		i += code
+		if code < 0xff && i < len(data) {
+			out = append(out, 0)
+		}
+	}
+	return out, true
+}
+
+/* SLIP encode data, enclosed in END bytes. */
+func slip_encode(data []byte) []byte {
+	out := make([]byte, 0, len(data)+len(data)/16+2)
+	out = append(out, slip_end)
+	for _, b := range data {
+		switch b {
+		case slip_end:
+			out = append(out, slip_esc, slip_esc_end)
+		case slip_esc:
+			out = append(out, slip_esc, slip_esc_esc)
+		default:
+			out = append(out, b)
+		}
+	}
+	return append(out, slip_end)
+}
+
+/* SLIP decode a frame without its END bytes. */
+func slip_decode(data []byte) ([]byte, bool) {
+	out := make([]byte, 0, len(data))
+	for i := 0; i < len(data); i++ {
+		b := data[i]
+		if b == slip_esc {
+			i++
+			if i == len(data) {
+				return nil, false
+			}
+			switch data[i] {
+			case slip_esc_end:
+				b = slip_end
+			case slip_esc_esc:
+				b = slip_esc
+			default:
+				return nil, false
+			}
+		}
+		out = append(out, b)
+	}
+	return out, true
+}
@@ -0,0 +1,159 @@
+package hsframe
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/whowechina/heatshrink"
+)
+
+var messages = [][]byte{
+	[]byte("hello, heatshrink"),
+	{},
+	bytes.Repeat([]byte{0x00}, 600), /* delimiters for COBS */
+	bytes.Repeat([]byte{slip_end, slip_esc}, 300), /* and for SLIP */
+	[]byte(strings.Repeat("temperature=21.5 humidity=40 ", 40)),
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, framing := range []Framing{COBS, SLIP} {
+		var line bytes.Buffer
+		fw, err := NewWriter(&line, framing, 8, 4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, msg := range messages {
+			if err := fw.WriteMessage(msg); err != nil {
+				t.Fatal(err)
+			}
+		}
+		fr, _ := NewReader(&line, framing, 8, 4)
+		for i, want := range messages {
+			got, err := fr.ReadMessage()
+			if err != nil || !bytes.Equal(got, want) {
+				t.Fatalf("framing %v, message %v: got %q (%v), want %q", framing, i, got, err, want)
+			}
+		}
+		if _, err := fr.ReadMessage(); err != io.EOF {
+			t.Errorf("framing %v: %v after the last message, want io.EOF", framing, err)
+		}
+		if fr.Dropped != 0 {
+			t.Errorf("framing %v: %v frames dropped, want none", framing, fr.Dropped)
+		}
+	}
+
+	fw, _ := NewWriter(io.Discard, COBS, 8, 4)
+	if err := fw.WriteMessage(make([]byte, MaxMessage+1)); err != ErrTooLong {
+		t.Errorf("WriteMessage(MaxMessage+1): %v, want ErrTooLong", err)
+	}
+}
+
+func TestCodecs(t *testing.T) {
+	for _, data := range [][]byte{
+		{},
+		{0x00},
+		{0x00, 0x00},
+		{0x11, 0x22, 0x00, 0x33},
+		bytes.Repeat([]byte{0x01}, 254),
+		bytes.Repeat([]byte{0x01}, 255),
+		bytes.Repeat([]byte{0x01}, 600),
+		{slip_end, slip_esc, slip_esc_end, slip_esc_esc},
+	} {
+		enc := cobs_encode(data)
+		if enc[0] != 0x00 || enc[len(enc)-1] != 0x00 || bytes.IndexByte(enc[1:len(enc)-1], 0x00) >= 0 {
+			t.Errorf("cobs_encode(% x) = % x, want 0x00 only at both ends", data, enc)
+		}
+		if dec, ok := cobs_decode(enc[1 : len(enc)-1]); !ok || !bytes.Equal(dec, data) {
+			t.Errorf("COBS round trip of % x gave % x (%v)", data, dec, ok)
+		}
+
+		enc = slip_encode(data)
+		if enc[0] != slip_end || enc[len(enc)-1] != slip_end || bytes.IndexByte(enc[1:len(enc)-1], slip_end) >= 0 {
+			t.Errorf("slip_encode(% x) = % x, want END only at both ends", data, enc)
+		}
+		if dec, ok := slip_decode(enc[1 : len(enc)-1]); !ok || !bytes.Equal(dec, data) {
+			t.Errorf("SLIP round trip of % x gave % x (%v)", data, dec, ok)
+		}
+	}
+
+	/* Codes running past the end, and bad escapes. */
+	for _, bad := range [][]byte{{0x05, 0x11}, {0x00}} {
+		if _, ok := cobs_decode(bad); ok {
+			t.Errorf("cobs_decode(% x) accepted", bad)
+		}
+	}
+	for _, bad := range [][]byte{{slip_esc}, {slip_esc, 0x11}} {
+		if _, ok := slip_decode(bad); ok {
+			t.Errorf("slip_decode(% x) accepted", bad)
+		}
+	}
+}
+
+/* The check value of CRC-16/CCITT-FALSE, and a frame failing it. */
+func TestCRC(t *testing.T) {
+	if crc := crc16([]byte("123456789")); crc != 0x29b1 {
+		t.Errorf("crc16(123456789) = %04x, want 29b1", crc)
+	}
+
+	for _, framing := range []Framing{COBS, SLIP} {
+		var frame bytes.Buffer
+		fw, _ := NewWriter(&frame, framing, 8, 4)
+		fw.WriteMessage([]byte("flipped"))
+		damaged := frame.Bytes()
+		damaged[len(damaged)/2] ^= 0x04
+		fw2, _ := NewWriter(&frame, framing, 8, 4)
+		fw2.WriteMessage([]byte("intact"))
+
+		fr, _ := NewReader(&frame, framing, 8, 4)
+		msg, err := fr.ReadMessage()
+		if err != nil || string(msg) != "intact" || fr.Dropped != 1 {
+			t.Errorf("framing %v: got %q (%v) with %v dropped, want the intact one after 1 dropped",
+				framing, msg, err, fr.Dropped)
+		}
+	}
+}
+
+/* Line noise, between frames or cutting one short, only costs the
+* frames it touches. */
+func TestRecovery(t *testing.T) {
+	for _, framing := range []Framing{COBS, SLIP} {
+		var line bytes.Buffer
+		fw, _ := NewWriter(&line, framing, 8, 4)
+		line.WriteString("\x17\x42garbage")
+		fw.WriteMessage([]byte("first"))
+		fw.WriteMessage([]byte("cut short"))
+		line.Truncate(line.Len() - 3)
+		line.WriteString("\xff\x01noise")
+		fw.WriteMessage([]byte("second"))
+
+		fr, _ := NewReader(&line, framing, 8, 4)
+		var got []string
+		for {
+			msg, err := fr.ReadMessage()
+			if err != nil {
+				break
+			}
+			got = append(got, string(msg))
+		}
+		if strings.Join(got, ",") != "first,second" {
+			t.Errorf("framing %v: got %q, want first and second", framing, got)
+		}
+		if fr.Dropped == 0 {
+			t.Errorf("framing %v: nothing counted as dropped", framing)
+		}
+	}
+}
+
+func TestInvalidParams(t *testing.T) {
+	for _, p := range [][2]uint8{{3, 2}, {16, 4}, {8, 8}, {8, 2}} {
+		if _, err := NewWriter(io.Discard, COBS, p[0], p[1]); !errors.Is(err, heatshrink.ErrParams) {
+			t.Errorf("NewWriter(%v, %v): %v, want ErrParams", p[0], p[1], err)
+		}
+		if _, err := NewReader(strings.NewReader(""), SLIP, p[0], p[1]); !errors.Is(err, heatshrink.ErrParams) {
+			t.Errorf("NewReader(%v, %v): %v, want ErrParams", p[0], p[1], err)
+		}
+	}
+}
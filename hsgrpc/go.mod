@@ -0,0 +1,10 @@
+module github.com/whowechina/heatshrink/hsgrpc
+
+go 1.25.0
+
+require (
+	github.com/whowechina/heatshrink v0.0.0-20261018160225-70b0682b987a
+	google.golang.org/grpc v1.82.1
+)
+
+require golang.org/x/sys v0.43.0 // indirect
@@ -0,0 +1,66 @@
+// Package hsgrpc registers heatshrink as a gRPC compressor.
+//
+// Importing the package registers "heatshrink-8-4" (a window of 2^8 and
+// a lookahead of 2^4 bytes). Other sizes can be registered with Register;
+// the name always follows the same pattern, see Name. Select it on the
+// client with grpc.UseCompressor(hsgrpc.Name(8, 4)).
+package hsgrpc
+
+import (
+	"fmt"
+	"io"
+
+	"google.golang.org/grpc/encoding"
+
+	"github.com/whowechina/heatshrink"
+)
+
+func init() {
+	Register(8, 4)
+}
+
+// Name returns the name the compressor for the given window and
+// lookahead sizes is registered under.
+func Name(window, lookahead uint8) string {
+	return fmt.Sprintf("heatshrink-%v-%v", window, lookahead)
+}
+
+// Register registers a compressor for the given window and lookahead
+// sizes. Like encoding.RegisterCompressor, it must only be called at
+// initialization time, from an init function.
+func Register(window, lookahead uint8) error {
+	c, err := NewCompressor(window, lookahead)
+	if err != nil {
+		return err
+	}
+	encoding.RegisterCompressor(c)
+	return nil
+}
+
+// NewCompressor returns a compressor for the given window and lookahead
+// sizes, without registering it.
+func NewCompressor(window, lookahead uint8) (encoding.Compressor, error) {
+	if window < heatshrink.HEATSHRINK_MIN_WINDOW_BITS ||
+		window > heatshrink.HEATSHRINK_MAX_WINDOW_BITS ||
+		lookahead < heatshrink.HEATSHRINK_MIN_LOOKAHEAD_BITS ||
+		lookahead >= window {
+		return nil, heatshrink.ErrParams
+	}
+	return &compressor{window: window, lookahead: lookahead}, nil
+}
+
+type compressor struct {
+	window, lookahead uint8
+}
+
+func (c *compressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	return heatshrink.NewWriter(w, c.window, c.lookahead)
+}
+
+func (c *compressor) Decompress(r io.Reader) (io.Reader, error) {
+	return heatshrink.NewReader(r, c.window, c.lookahead)
+}
+
+func (c *compressor) Name() string {
+	return Name(c.window, c.lookahead)
+}
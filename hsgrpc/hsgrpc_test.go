@@ -0,0 +1,59 @@
+package hsgrpc
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/encoding"
+
+	"github.com/whowechina/heatshrink"
+)
+
+func roundTrip(t *testing.T, c encoding.Compressor, msg []byte) {
+	var buf bytes.Buffer
+	zw, err := c.Compress(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw.Write(msg)
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := c.Decompress(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(zr)
+	if err != nil || !bytes.Equal(got, msg) {
+		t.Errorf("%v: round trip gave %q (%v), want %q", c.Name(), got, err, msg)
+	}
+}
+
+func TestRegistered(t *testing.T) {
+	c := encoding.GetCompressor(Name(8, 4))
+	if c == nil {
+		t.Fatalf("no compressor registered as %q", Name(8, 4))
+	}
+	roundTrip(t, c, []byte(strings.Repeat("request, response, ", 100)))
+	roundTrip(t, c, nil)
+}
+
+func TestNewCompressor(t *testing.T) {
+	c, err := NewCompressor(12, 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Name() != "heatshrink-12-6" {
+		t.Errorf("Name() = %q, want heatshrink-12-6", c.Name())
+	}
+	roundTrip(t, c, []byte(strings.Repeat("stream of messages ", 300)))
+
+	for _, p := range [][2]uint8{{3, 2}, {16, 4}, {8, 8}, {8, 2}} {
+		if _, err := NewCompressor(p[0], p[1]); !errors.Is(err, heatshrink.ErrParams) {
+			t.Errorf("NewCompressor(%v, %v): %v, want ErrParams", p[0], p[1], err)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+// Package hshttp provides heatshrink content coding for net/http servers
+// and clients.
+//
+// As heatshrink streams can only be decoded with the window and lookahead
+// sizes they were compressed with, the content coding token carries both:
+// "heatshrink-8-4" is a window of 2^8 and a lookahead of 2^4 bytes. A
+// client advertises the sizes it can decode in Accept-Encoding, e.g.
+//
+//	Accept-Encoding: heatshrink-8-4, heatshrink-10-5;q=0.5
+package hshttp
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/whowechina/heatshrink"
+)
+
+const prefix = "heatshrink-"
+
+// Coding returns the content coding token for the given window and
+// lookahead sizes.
+func Coding(window, lookahead uint8) string {
+	return fmt.Sprintf("%v%v-%v", prefix, window, lookahead)
+}
+
+// ParseCoding parses a content coding token as returned by Coding. It
+// reports false if s isn't a heatshrink coding with valid sizes.
+func ParseCoding(s string) (window, lookahead uint8, ok bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if !strings.HasPrefix(s, prefix) {
+		return 0, 0, false
+	}
+	w, l, found := strings.Cut(s[len(prefix):], "-")
+	if !found {
+		return 0, 0, false
+	}
+	wi, err1 := strconv.ParseUint(w, 10, 8)
+	li, err2 := strconv.ParseUint(l, 10, 8)
+	if err1 != nil || err2 != nil ||
+		wi < heatshrink.HEATSHRINK_MIN_WINDOW_BITS ||
+		wi > heatshrink.HEATSHRINK_MAX_WINDOW_BITS ||
+		li < heatshrink.HEATSHRINK_MIN_LOOKAHEAD_BITS || li >= wi {
+		return 0, 0, false
+	}
+	return uint8(wi), uint8(li), true
+}
+
+/* Pick the heatshrink coding the client prefers (highest q, first one
+* on a tie) from an Accept-Encoding header. */
+func negotiate(accept string) (window, lookahead uint8, ok bool) {
+	best := 0.0
+	for _, part := range strings.Split(accept, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		w, l, valid := ParseCoding(coding)
+		if !valid {
+			continue
+		}
+		q := 1.0
+		for _, p := range strings.Split(params, ";") {
+			k, v, _ := strings.Cut(strings.TrimSpace(p), "=")
+			if strings.EqualFold(k, "q") {
+				if f, err := strconv.ParseFloat(v, 64); err == nil {
+					q = f
+				}
+			}
+		}
+		if q > best {
+			best, window, lookahead, ok = q, w, l, true
+		}
+	}
+	return window, lookahead, ok
+}
+
+// Handler compresses the responses of h for clients that accept a
+// heatshrink coding. Responses that already have a Content-Encoding, and
+// partial content (206) responses, are passed through as they are. The
+// response writer given to h implements http.Flusher, but only flushes
+// the connection: up to a window's worth of the data written before
+// stays in the compressor, as flushing that out (see
+// heatshrink.Writer.Flush) would make the stream non-standard.
+func Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		window, lookahead, ok := negotiate(r.Header.Get("Accept-Encoding"))
+		if !ok || r.Method == http.MethodHead {
+			h.ServeHTTP(w, r)
+			return
+		}
+		rw := &responseWriter{ResponseWriter: w, window: window, lookahead: lookahead}
+		defer rw.close()
+		h.ServeHTTP(rw, r)
+	})
+}
+
+type responseWriter struct {
+	http.ResponseWriter
+	window, lookahead uint8
+	zw                *heatshrink.Writer /* nil until WriteHeader, or if passing through */
+	code              int                /* status given to WriteHeader, 0 before */
+	committed         bool               /* header passed on to ResponseWriter */
+}
+
+/* The header isn't passed on right away: net/http only sniffs the
+* Content-Type of responses without a Content-Encoding, so that is left
+* to commit, which sees the first bytes written. */
+func (rw *responseWriter) WriteHeader(code int) {
+	if code >= 100 && code <= 199 && code != http.StatusSwitchingProtocols {
+		/* informational, the real status is still to come */
+		rw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if rw.code != 0 {
+		return
+	}
+	rw.code = code
+	hdr := rw.Header()
+	if hdr.Get("Content-Encoding") == "" && code != http.StatusNoContent &&
+		code != http.StatusNotModified && code != http.StatusPartialContent {
+		hdr.Set("Content-Encoding", Coding(rw.window, rw.lookahead))
+		hdr.Del("Content-Length")
+		rw.zw, _ = heatshrink.NewWriter(rw.ResponseWriter, rw.window, rw.lookahead)
+	}
+}
+
+/* Pass the header on, with a Content-Type sniffed from p (the
+* uncompressed start of the body) if compressing and none is set. */
+func (rw *responseWriter) commit(p []byte) {
+	if rw.committed {
+		return
+	}
+	rw.committed = true
+	if rw.code == 0 {
+		rw.WriteHeader(http.StatusOK)
+	}
+	hdr := rw.Header()
+	if _, haveType := hdr["Content-Type"]; rw.zw != nil && !haveType && len(p) > 0 {
+		hdr.Set("Content-Type", http.DetectContentType(p))
+	}
+	rw.ResponseWriter.WriteHeader(rw.code)
+}
+
+func (rw *responseWriter) Write(p []byte) (int, error) {
+	rw.commit(p)
+	if rw.zw == nil {
+		return rw.ResponseWriter.Write(p)
+	}
+	return rw.zw.Write(p)
+}
+
+func (rw *responseWriter) Flush() {
+	rw.commit(nil)
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the underlying ResponseWriter, for
+// http.ResponseController.
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+func (rw *responseWriter) close() {
+	/* Without a WriteHeader or Write, the handler sent nothing: leave
+	* the response to net/http, uncompressed. */
+	if rw.code == 0 {
+		return
+	}
+	rw.commit(nil)
+	if rw.zw != nil {
+		rw.zw.Close()
+	}
+}
+
+// DecodeRequests decompresses request bodies sent to h with a heatshrink
+// Content-Encoding. Requests with any other Content-Encoding are passed
+// on unchanged.
+func DecodeRequests(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if window, lookahead, ok := ParseCoding(r.Header.Get("Content-Encoding")); ok {
+			zr, _ := heatshrink.NewReader(r.Body, window, lookahead)
+			r.Body = readCloser{zr, r.Body}
+			r.Header.Del("Content-Encoding")
+			r.Header.Del("Content-Length")
+			r.ContentLength = -1
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// Transport is an http.RoundTripper that offers a heatshrink coding for
+// responses and decompresses them, and optionally compresses request
+// bodies.
+type Transport struct {
+	Base              http.RoundTripper /* http.DefaultTransport if nil */
+	Window, Lookahead uint8
+	CompressRequests  bool
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	coding := Coding(t.Window, t.Lookahead)
+	if _, _, ok := ParseCoding(coding); !ok {
+		if req.Body != nil {
+			req.Body.Close() /* as RoundTrippers must, even on errors */
+		}
+		return nil, heatshrink.ErrParams
+	}
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	req = req.Clone(req.Context())
+	if req.Header.Get("Accept-Encoding") == "" {
+		req.Header.Set("Accept-Encoding", coding)
+	}
+	if t.CompressRequests && req.Body != nil && req.Body != http.NoBody &&
+		req.Header.Get("Content-Encoding") == "" {
+		body := req.Body
+		pr, pw := io.Pipe()
+		go func() {
+			defer body.Close()
+			zw, _ := heatshrink.NewWriter(pw, t.Window, t.Lookahead)
+			_, err := io.Copy(zw, body)
+			if err == nil {
+				err = zw.Close()
+			}
+			pw.CloseWithError(err)
+		}()
+		req.Body = pr
+		req.GetBody = nil
+		req.ContentLength = -1
+		req.Header.Del("Content-Length")
+		req.Header.Set("Content-Encoding", coding)
+	}
+
+	resp, err := base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if window, lookahead, ok := ParseCoding(resp.Header.Get("Content-Encoding")); ok {
+		zr, _ := heatshrink.NewReader(resp.Body, window, lookahead)
+		resp.Body = readCloser{zr, resp.Body}
+		resp.Header.Del("Content-Encoding")
+		resp.Header.Del("Content-Length")
+		resp.ContentLength = -1
+		resp.Uncompressed = true
+	}
+	return resp, nil
+}
@@ -0,0 +1,181 @@
+package hshttp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/whowechina/heatshrink"
+)
+
+func TestHandler(t *testing.T) {
+	page := "<html><body>" + strings.Repeat("hello, heatshrink! ", 50) + "</body></html>"
+	srv := httptest.NewServer(Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+			t.Errorf("ResponseController: %v", err)
+		}
+		io.WriteString(w, page)
+	})))
+	defer srv.Close()
+
+	client := &http.Client{Transport: &Transport{Window: 8, Lookahead: 4}}
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.Uncompressed || string(body) != page {
+		t.Errorf("got %q (uncompressed %v), want the page", body, resp.Uncompressed)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/html; charset=utf-8" {
+		t.Errorf("Content-Type %q, want it sniffed from the uncompressed body", ct)
+	}
+}
+
+/* Early hints go out as they are, and the real status still follows. */
+func TestHandlerInformational(t *testing.T) {
+	srv := httptest.NewServer(Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", "</style.css>; rel=preload")
+		w.WriteHeader(http.StatusEarlyHints)
+		w.WriteHeader(http.StatusTeapot)
+		io.WriteString(w, "short and stout")
+	})))
+	defer srv.Close()
+
+	client := &http.Client{Transport: &Transport{Window: 8, Lookahead: 4}}
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusTeapot || string(body) != "short and stout" {
+		t.Errorf("got %v %q, want 418 and the body", resp.StatusCode, body)
+	}
+}
+
+/* Partial content is left alone: its ranges are of the uncompressed body. */
+func TestHandlerPartialContent(t *testing.T) {
+	content := strings.Repeat("0123456789", 100)
+	srv := httptest.NewServer(Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "digits.txt", time.Time{}, strings.NewReader(content))
+	})))
+	defer srv.Close()
+
+	req, _ := http.NewRequest("GET", srv.URL, nil)
+	req.Header.Set("Range", "bytes=10-19")
+	client := &http.Client{Transport: &Transport{Window: 8, Lookahead: 4}}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusPartialContent || resp.Uncompressed || string(body) != content[10:20] {
+		t.Errorf("got %v %q (uncompressed %v), want the range as it is",
+			resp.StatusCode, body, resp.Uncompressed)
+	}
+}
+
+/* Flushing must not put the (non-standard) sync marker in the stream:
+* whatever a flush lets through decodes as a plain stream would. */
+func TestHandlerFlush(t *testing.T) {
+	page := strings.Repeat("tick tock ", 100)
+	flushed := make(chan struct{})
+	srv := httptest.NewServer(Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, page)
+		w.(http.Flusher).Flush()
+		<-flushed
+		io.WriteString(w, page)
+	})))
+	defer srv.Close()
+
+	req, _ := http.NewRequest("GET", srv.URL, nil)
+	req.Header.Set("Accept-Encoding", Coding(8, 4))
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ce := resp.Header.Get("Content-Encoding"); ce != Coding(8, 4) {
+		t.Fatalf("Content-Encoding %q, want %q", ce, Coding(8, 4))
+	}
+	close(flushed)
+	comp, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(comp, heatshrink.Compress(8, 4, []byte(page+page))) {
+		t.Errorf("flushed response differs from Compress of the body")
+	}
+}
+
+/* A body compressed by Transport comes out of DecodeRequests as sent. */
+func TestDecodeRequests(t *testing.T) {
+	upload := strings.Repeat("upload, upload, upload! ", 200)
+	srv := httptest.NewServer(DecodeRequests(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ce := r.Header.Get("Content-Encoding"); ce != "" {
+			t.Errorf("Content-Encoding %q left on the decoded request", ce)
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil || string(body) != upload {
+			t.Errorf("request body %q (%v), want the upload", body, err)
+		}
+		io.WriteString(w, "ok")
+	})))
+	defer srv.Close()
+
+	client := &http.Client{Transport: &Transport{Window: 10, Lookahead: 5, CompressRequests: true}}
+	resp, err := client.Post(srv.URL, "text/plain", strings.NewReader(upload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	/* Other codings are passed on untouched. */
+	srv2 := httptest.NewServer(DecodeRequests(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if ce := r.Header.Get("Content-Encoding"); ce != "gzip" || string(body) != "not really" {
+			t.Errorf("got %q with Content-Encoding %q, want it unchanged", body, ce)
+		}
+	})))
+	defer srv2.Close()
+	req, _ := http.NewRequest("POST", srv2.URL, strings.NewReader("not really"))
+	req.Header.Set("Content-Encoding", "gzip")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+}
+
+type closeCounter struct {
+	io.Reader
+	closed int
+}
+
+func (c *closeCounter) Close() error {
+	c.closed++
+	return nil
+}
+
+func TestTransportBadParams(t *testing.T) {
+	body := &closeCounter{Reader: strings.NewReader("data")}
+	req, _ := http.NewRequest("POST", "http://example.invalid/", body)
+	_, err := (&Transport{Window: 3, Lookahead: 2}).RoundTrip(req)
+	if !errors.Is(err, heatshrink.ErrParams) {
+		t.Errorf("RoundTrip: %v, want ErrParams", err)
+	}
+	if body.closed != 1 {
+		t.Errorf("request body closed %v times, want once", body.closed)
+	}
+}
@@ -0,0 +1,14 @@
+package heatshrink
+
+import (
+	"log"
+)
+
+/* Debugging logs, as with HEATSHRINK_DEBUGGING_LOGS in the C version.
+* Off unless built with the heatshrink_debug tag: as a constant, the
+* compiler drops the calls, arguments and all, so they cost nothing. */
+func debug_log(format string, args ...interface{}) {
+	if heatshrink_debugging_logs {
+		log.Printf(format, args...)
+	}
+}
@@ -0,0 +1,5 @@
+//go:build heatshrink_debug
+
+package heatshrink
+
+const heatshrink_debugging_logs = true
@@ -0,0 +1,251 @@
+package heatshrink
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+)
+
+/* Members are self-describing heatshrink streams, which can simply be
+* concatenated (say, appended to a log file one at a time) and decoded
+* in one go by a MemberReader. Each member is a header, followed by the
+* compressed data:
+*
+*   magic       2 bytes, "HS"
+*   version     bitstream format: MEMBER_VERSION for plain heatshrink,
+*               MEMBER_VERSION_ENTROPY for entropy coded (see entropy.go),
+*               MEMBER_VERSION_VARIABLE for variable-length (variable.go)
+*   sizes       window bits << 4 | lookahead bits; for variable-length
+*               members, (window bits - 4) << 4 | (lookahead bits - 3)
+*   flags       the filter the data went through, see filter.go
+*   length      4 bytes, little-endian: size of the compressed data
+*   size        4 bytes, little-endian: size of the uncompressed data
+*
+* The lengths make member boundaries unambiguous, whatever padding the
+* end of the compressed data has. */
+const (
+	MEMBER_MAGIC            = "HS"
+	MEMBER_VERSION          = 1
+	MEMBER_VERSION_ENTROPY  = 2
+	MEMBER_VERSION_VARIABLE = 3
+	MEMBER_HEADER_SIZE      = 13
+)
+
+type member_header struct {
+	version       uint8
+	window_sz2    uint8
+	lookahead_sz2 uint8
+	flags         uint8
+	length        uint32
+	size          uint32
+}
+
+// CompressMember compresses data into a member: a stream with a header
+// giving its sizes, so that members can be concatenated and decoded in
+// sequence with a MemberReader.
+//
+// With a filter option, the header records the filter, and MemberReader
+// undoes it; a plain heatshrink decoder decodes the filtered data. The
+// window options aren't recorded, and so are ignored: members always
+// start with an all-zero window.
+func CompressMember(window, lookahead uint8, data []byte, opts ...Option) []byte {
+	flags, ok := filter_flags(get_options(opts))
+	if !valid_params(window, lookahead) || !ok {
+		return nil
+	}
+	/* The header has no room for the window options, so MemberReader
+	* always starts with a zeroed window: leave them out. */
+	opts = append(opts[:len(opts):len(opts)], without_window)
+	out := Compress(window, lookahead, data, opts...)
+	return append_member(MEMBER_VERSION, flags, window, lookahead, data, out)
+}
+
+// CompressEntropyMember is like CompressMember, but with entropy coded
+// data (see CompressEntropy) in the member. MemberReader decodes both,
+// but plain heatshrink decoders don't: this is for host to host
+// transfers.
+func CompressEntropyMember(window, lookahead uint8, data []byte, opts ...Option) []byte {
+	flags, ok := filter_flags(get_options(opts))
+	if !valid_params(window, lookahead) || !ok {
+		return nil
+	}
+	out := CompressEntropy(window, lookahead, filter_encode(flags, data), opts...)
+	return append_member(MEMBER_VERSION_ENTROPY, flags, window, lookahead, data, out)
+}
+
+// CompressVariableMember is like CompressMember, but with variable-length
+// data (see CompressVariable) in the member, which allows windows of up to
+// HEATSHRINK_MAX_VARIABLE_WINDOW_BITS. MemberReader decodes both, but
+// plain heatshrink decoders don't.
+func CompressVariableMember(window, lookahead uint8, data []byte, opts ...Option) []byte {
+	flags, ok := filter_flags(get_options(opts))
+	if !valid_variable_params(window, lookahead) || !ok {
+		return nil
+	}
+	out := CompressVariable(window, lookahead, filter_encode(flags, data), opts...)
+	return append_member(MEMBER_VERSION_VARIABLE, flags, window, lookahead, data, out)
+}
+
+func append_member(version, flags, window, lookahead uint8, data, out []byte) []byte {
+	h := member_header{
+		version:       version,
+		flags:         flags,
+		window_sz2:    window,
+		lookahead_sz2: lookahead,
+		length:        uint32(len(out)),
+		size:          uint32(len(data)),
+	}
+	return append(append_member_header(nil, &h), out...)
+}
+
+// DecompressMembers decompresses all the members in data, one after the
+// other.
+func DecompressMembers(data []byte) ([]byte, error) {
+	return ioutil.ReadAll(NewMemberReader(bytes.NewReader(data)))
+}
+
+func append_member_header(b []byte, h *member_header) []byte {
+	b = append(b, MEMBER_MAGIC...)
+	sizes := h.window_sz2<<4 | h.lookahead_sz2
+	if h.version == MEMBER_VERSION_VARIABLE {
+		sizes = (h.window_sz2-HEATSHRINK_MIN_WINDOW_BITS)<<4 |
+			(h.lookahead_sz2 - HEATSHRINK_MIN_LOOKAHEAD_BITS)
+	}
+	b = append(b, h.version, sizes, h.flags)
+	b = binary.LittleEndian.AppendUint32(b, h.length)
+	return binary.LittleEndian.AppendUint32(b, h.size)
+}
+
+func parse_member_header(b []byte) (*member_header, error) {
+	h := &member_header{
+		version:       b[2],
+		window_sz2:    b[3] >> 4,
+		lookahead_sz2: b[3] & 0x0f,
+		flags:         b[4],
+		length:        binary.LittleEndian.Uint32(b[5:]),
+		size:          binary.LittleEndian.Uint32(b[9:]),
+	}
+	if h.version == MEMBER_VERSION_VARIABLE {
+		h.window_sz2 += HEATSHRINK_MIN_WINDOW_BITS
+		h.lookahead_sz2 += HEATSHRINK_MIN_LOOKAHEAD_BITS
+	}
+	if string(b[:2]) != MEMBER_MAGIC || !valid_filter_flags(h.flags) ||
+		(h.version != MEMBER_VERSION && h.version != MEMBER_VERSION_ENTROPY &&
+			h.version != MEMBER_VERSION_VARIABLE) {
+		return nil, ErrHeader
+	}
+	return h, nil
+}
+
+// MemberReader decompresses a sequence of members, as made by
+// CompressMember, read from an underlying io.Reader.
+// Errors about the data are DecodeErrors, with offsets counted from the
+// start of the underlying reader.
+type MemberReader struct {
+	r      io.Reader
+	zr     io.Reader /* current member, nil between members */
+	left   int64     /* bytes of the current member not read yet */
+	err    error
+	offset int64 /* of the current (or next) member in the input */
+	length int64 /* of the current member, header included */
+	out    int64 /* bytes output so far */
+}
+
+func NewMemberReader(r io.Reader) *MemberReader {
+	return &MemberReader{r: r}
+}
+
+func (z *MemberReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, z.err
+	}
+	for z.err == nil {
+		if z.zr == nil {
+			z.err = z.next_member()
+			continue
+		}
+		n, err := z.zr.Read(p)
+		z.left -= int64(n)
+		z.out += int64(n)
+		if z.left < 0 {
+			z.err = z.member_error(ErrCorrupt, z.length)
+			return 0, z.err
+		}
+		if err == io.EOF {
+			if z.left != 0 {
+				z.err = z.member_error(ErrCorrupt, z.length)
+			}
+			z.zr = nil
+			z.offset += z.length
+		} else if err != nil {
+			z.err = err
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
+	return 0, z.err
+}
+
+func (z *MemberReader) next_member() error {
+	var hdr [MEMBER_HEADER_SIZE]byte
+	if n, err := io.ReadFull(z.r, hdr[:]); err == io.ErrUnexpectedEOF {
+		return z.member_error(ErrShortInput, int64(n)) /* header cut short */
+	} else if err != nil {
+		return err /* io.EOF only if there's nothing at all */
+	}
+	h, err := parse_member_header(hdr[:])
+	if err != nil {
+		return z.member_error(err, 0)
+	}
+	z.left = int64(h.size)
+	z.length = MEMBER_HEADER_SIZE + int64(h.length)
+	if h.version != MEMBER_VERSION || h.flags != FILTER_NONE {
+		/* The extended formats and filters don't stream, decode them
+		* in one go. */
+		data, err := ioutil.ReadAll(io.LimitReader(z.r, int64(h.length)))
+		if err != nil {
+			return err
+		}
+		if len(data) < int(h.length) { /* member cut short */
+			return z.member_error(ErrCorrupt, MEMBER_HEADER_SIZE+int64(len(data)))
+		}
+		var out []byte
+		switch h.version {
+		case MEMBER_VERSION:
+			if !valid_params(h.window_sz2, h.lookahead_sz2) {
+				return z.member_error(ErrHeader, 0)
+			}
+			out, _, _ = decode_fast(h.window_sz2, h.lookahead_sz2, nil, data, nil, -1)
+		case MEMBER_VERSION_ENTROPY:
+			out, err = DecompressEntropy(h.window_sz2, h.lookahead_sz2, data, int(h.size))
+		default:
+			out, err = DecompressVariable(h.window_sz2, h.lookahead_sz2, data)
+		}
+		if err == ErrParams {
+			return z.member_error(ErrHeader, 0)
+		} else if e, ok := err.(*DecodeError); ok {
+			/* from the start of the member data to the start of the input */
+			e.Offset += int(z.offset) + MEMBER_HEADER_SIZE
+			e.Output += int(z.out)
+			return e
+		} else if err != nil {
+			return err
+		}
+		filter_decode(h.flags, out)
+		z.zr = bytes.NewReader(out)
+		return nil
+	}
+	zr, err := NewReader(io.LimitReader(z.r, int64(h.length)), h.window_sz2, h.lookahead_sz2)
+	if err != nil {
+		return z.member_error(ErrHeader, 0)
+	}
+	z.zr = zr
+	return nil
+}
+
+/* A DecodeError for err at offset bytes into the current member. */
+func (z *MemberReader) member_error(err error, offset int64) error {
+	return &DecodeError{Offset: int(z.offset + offset), Output: int(z.out), Err: err}
+}
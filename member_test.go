@@ -0,0 +1,52 @@
+package heatshrink
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+	"testing/iotest"
+)
+
+/* Members can't record the window options, so they must not use them. */
+func TestMemberWindowOptions(t *testing.T) {
+	data := append(bytes.Repeat([]byte{0xff}, 40), "hello hello hello"...)
+	for _, opt := range []Option{WithWindowFill(0xff), WithWindow(data)} {
+		m := CompressMember(8, 4, data, opt)
+		if out, err := DecompressMembers(m); err != nil || !bytes.Equal(out, data) {
+			t.Errorf("got %x (%v), want %x", out, err, data)
+		}
+	}
+}
+
+func TestMemberTruncatedHeader(t *testing.T) {
+	m := CompressMember(8, 4, []byte("hello hello hello"))
+	data := append(append([]byte{}, m...), m[:5]...)
+	out, err := DecompressMembers(data)
+	var de *DecodeError
+	if !errors.As(err, &de) || !errors.Is(err, ErrShortInput) || de.Offset != len(m)+5 {
+		t.Fatalf("got %v, want ErrShortInput at offset %v", err, len(m)+5)
+	}
+	if string(out) != "hello hello hello" || de.Output != len(out) {
+		t.Errorf("got %q, output %v; want the first member", out, de.Output)
+	}
+}
+
+/* Members of all sizes, concatenated, read back as one stream. */
+func TestMembers(t *testing.T) {
+	r := rand.New(rand.NewSource(7))
+	var members, want []byte
+	for i := 0; i < 12; i++ {
+		data := test_corpus(int64(i), r.Intn(5000))
+		members = append(members, CompressMember(8+uint8(i%3), 4, data)...)
+		want = append(want, data...)
+	}
+	if got, err := DecompressMembers(members); err != nil || !bytes.Equal(got, want) {
+		t.Errorf("DecompressMembers: mismatch (%v)", err)
+	}
+	zr := NewMemberReader(iotest.OneByteReader(bytes.NewReader(members)))
+	if got, err := ioutil.ReadAll(iotest.OneByteReader(zr)); err != nil || !bytes.Equal(got, want) {
+		t.Errorf("MemberReader: mismatch (%v)", err)
+	}
+}
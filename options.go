@@ -0,0 +1,91 @@
+package heatshrink
+
+// An Option changes how data is compressed. Whatever the options, the
+// output is standard heatshrink data, which decodes with any decoder of
+// the same window and lookahead sizes; with a filter (see filter.go),
+// though, the decoded data still has to go through the filter backwards,
+// and with the window options (see dict.go), the decoder has to start
+// with the same window. Decoders take the same options for those, and
+// ignore the others.
+type Option func(o *options)
+
+type options struct {
+	min_match int    /* shortest match worth a backref, 0 for the default */
+	strict    bool   /* break even counting the tag bit of literals */
+	filter    uint8  /* FILTER_* */
+	stride    int    /* of FILTER_DELTA */
+	fill      byte   /* initial window contents, see dict.go */
+	preset    []byte /* more of them, aligned to the end of the window */
+}
+
+/* By default, as in the C library, a match is used if it is longer than
+* break_even_point/8 bytes, where break_even_point is the size of a
+* backref in bits (1 + window + lookahead). Since a literal really takes
+* 9 bits, that only picks backrefs that are smaller than the literals
+* they replace, but misses some that would be: with a 12 bit window and
+* 4 bit lookahead, a 2 byte match takes 17 bits as a backref and 18 as
+* literals, but isn't used.
+*
+* A length 1 backref is never used, whatever the options: with offset 1
+* it would be a sync marker (see est_sync_bit_buffer). */
+
+// WithStrictBreakEven makes the encoder use every match that takes fewer
+// bits as a backref than as literals, counting their tag bits. Depending
+// on the window and lookahead sizes this picks some shorter matches than
+// the default. Each of them saves a bit or two, but as the encoder takes
+// the longest match at each position without looking ahead, a short
+// backref can also swallow the start of a longer match, so whether the
+// ratio improves depends on the data. Decoding a short backref is about
+// as fast as decoding the literals it replaces.
+func WithStrictBreakEven() Option {
+	return func(o *options) {
+		o.strict = true
+	}
+}
+
+// WithMinMatch sets the shortest match the encoder uses as a backref,
+// instead of the break-even point (n is at least 2). A higher minimum
+// gives fewer, longer backrefs and more literals: the ratio usually gets
+// worse, and more literals means more tokens to decode per output byte,
+// so decoding gets slower too. It can still pay off on data where short
+// matches get in the way of longer ones just after them.
+func WithMinMatch(n int) Option {
+	return func(o *options) {
+		o.min_match = n
+	}
+}
+
+/* The defaults, shared (and never to be changed) so that calls without
+* options don't allocate: o escapes to the Option functions. */
+var default_options options
+
+func get_options(opts []Option) *options {
+	if len(opts) == 0 {
+		return &default_options
+	}
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+/* Set up hse for opts, or the defaults if there are none. */
+func encoder_options(hse *encoder, opts []Option) {
+	o := get_options(opts)
+	break_even_point := 1 + uint16(hse.window_sz2) + uint16(hse.lookahead_sz2)
+	switch {
+	case o.min_match > 0:
+		hse.min_match = uint16(o.min_match)
+		if o.min_match > 1<<hse.lookahead_sz2 {
+			hse.min_match = 1<<hse.lookahead_sz2 + 1 /* no backrefs at all */
+		}
+	case o.strict:
+		hse.min_match = break_even_point/9 + 1
+	default:
+		hse.min_match = break_even_point/8 + 1
+	}
+	if hse.min_match < 2 {
+		hse.min_match = 2
+	}
+}
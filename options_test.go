@@ -0,0 +1,64 @@
+package heatshrink
+
+import (
+	"bytes"
+	"testing"
+)
+
+/* The shortest and longest backrefs in a stream, 0 if there are none. */
+func backref_lengths(window, lookahead uint8, comp []byte) (shortest, longest int) {
+	for _, tok := range Disassemble(window, lookahead, comp) {
+		if tok.Kind != TokenBackref {
+			continue
+		}
+		if shortest == 0 || tok.Length < shortest {
+			shortest = tok.Length
+		}
+		if tok.Length > longest {
+			longest = tok.Length
+		}
+	}
+	return shortest, longest
+}
+
+func TestMinMatch(t *testing.T) {
+	data := test_corpus(12, 8000)
+	if shortest, _ := backref_lengths(8, 4, Compress(8, 4, data)); shortest >= 5 {
+		t.Fatalf("shortest backref %v by default, want some under 5", shortest)
+	}
+	for _, n := range []int{3, 5, 9} {
+		comp := Compress(8, 4, data, WithMinMatch(n))
+		shortest, _ := backref_lengths(8, 4, comp)
+		if shortest < n {
+			t.Errorf("WithMinMatch(%v): backref of %v bytes", n, shortest)
+		}
+		if got := Decompress(8, 4, comp); !bytes.Equal(got, data) {
+			t.Errorf("WithMinMatch(%v): round trip mismatch", n)
+		}
+	}
+
+	/* Beyond the longest backref there can be: all literals. */
+	comp := Compress(8, 4, data, WithMinMatch(17))
+	if _, longest := backref_lengths(8, 4, comp); longest != 0 {
+		t.Errorf("WithMinMatch(17): backref of %v bytes with a lookahead of 16", longest)
+	}
+	if got := Decompress(8, 4, comp); !bytes.Equal(got, data) {
+		t.Errorf("WithMinMatch(17): round trip mismatch")
+	}
+}
+
+/* With a window of 8 and a lookahead of 7 bits, a backref takes 16 bits
+* and two literals 18: only the strict rule uses 2 byte matches. */
+func TestStrictBreakEven(t *testing.T) {
+	data := test_corpus(13, 8000)
+	if shortest, _ := backref_lengths(8, 7, Compress(8, 7, data)); shortest < 3 {
+		t.Fatalf("backref of %v bytes by default, want at least 3", shortest)
+	}
+	comp := Compress(8, 7, data, WithStrictBreakEven())
+	if shortest, _ := backref_lengths(8, 7, comp); shortest != 2 {
+		t.Errorf("shortest backref %v with WithStrictBreakEven, want 2", shortest)
+	}
+	if got := Decompress(8, 7, comp); !bytes.Equal(got, data) {
+		t.Errorf("round trip mismatch")
+	}
+}
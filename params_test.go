@@ -0,0 +1,43 @@
+package heatshrink
+
+import "testing"
+
+/* Invalid sizes are reported (or give no output), like the decoders do,
+* rather than panicking. */
+func TestInvalidParams(t *testing.T) {
+	data := []byte("hello hello hello")
+	dst := []byte("dst")
+	for _, p := range [][2]uint8{{16, 4}, {3, 2}, {8, 8}, {8, 2}} {
+		w, l := p[0], p[1]
+		if out := Compress(w, l, data); out != nil {
+			t.Errorf("Compress(%v, %v) = %x, want nil", w, l, out)
+		}
+		if out, st := CompressWithStats(w, l, data); out != nil || st != nil {
+			t.Errorf("CompressWithStats(%v, %v) = %x, %v, want nil", w, l, out, st)
+		}
+		if out := CompressWithDict(w, l, data, data); out != nil {
+			t.Errorf("CompressWithDict(%v, %v) = %x, want nil", w, l, out)
+		}
+		if out := CompressMember(w, l, data); out != nil {
+			t.Errorf("CompressMember(%v, %v) = %x, want nil", w, l, out)
+		}
+		if out := CompressEntropy(w, l, data); out != nil {
+			t.Errorf("CompressEntropy(%v, %v) = %x, want nil", w, l, out)
+		}
+		if out := CompressEntropyMember(w, l, data); out != nil {
+			t.Errorf("CompressEntropyMember(%v, %v) = %x, want nil", w, l, out)
+		}
+		if _, err := DecompressEntropy(w, l, data, len(data)); err != ErrParams {
+			t.Errorf("DecompressEntropy(%v, %v): %v, want ErrParams", w, l, err)
+		}
+		if out := AppendCompress(dst, data, w, l); string(out) != "dst" {
+			t.Errorf("AppendCompress(%v, %v) = %q, want dst", w, l, out)
+		}
+		if out := AppendDecompress(dst, data, w, l); string(out) != "dst" {
+			t.Errorf("AppendDecompress(%v, %v) = %q, want dst", w, l, out)
+		}
+	}
+	if _, err := DecompressEntropy(8, 4, data, -1); err != ErrParams {
+		t.Errorf("DecompressEntropy(size -1): %v, want ErrParams", err)
+	}
+}
@@ -0,0 +1,59 @@
+package heatshrink
+
+// DecompressPrefix decompresses size bytes from a stream at the start of
+// src, which may be followed by unrelated data, say the next record of a
+// packed structure. It reports how many bits of src the stream took, up
+// to the end of the token that produced the last byte; the stream's last
+// byte is src[(nbits+7)/8-1], and what follows it isn't part of the
+// stream (unless the stream was flushed right before it ended, see
+// Writer.Flush: the sync marker isn't counted).
+//
+// It returns ErrShortInput (in a DecodeError, as all decoding errors
+// about the data) if src ends before size bytes are output, and
+// ErrTrailingData if the last backref runs past size bytes: then the
+// stream isn't size bytes long, and nbits doesn't mark its end.
+func DecompressPrefix(window, lookahead uint8, src []byte, size int, opts ...Option) (out []byte, nbits int, err error) {
+	if !valid_params(window, lookahead) {
+		return nil, 0, ErrParams
+	}
+	if size < 0 {
+		size = 0
+	}
+	dict := preset_dict(get_options(opts), window, nil)
+	out, nbits, cut := decode_fast(window, lookahead, dict, src, nil, size)
+	if len(out) < size {
+		state := partial_state(window, lookahead, src, nbits)
+		return out, 8 * len(src), decode_error(ErrShortInput, 8*len(src), state, len(out))
+	}
+	if cut > 0 { /* backref runs past size */
+		return out, nbits, decode_error(ErrTrailingData, nbits, HSDS_YIELD_BACKREF, len(out))
+	}
+	return out, nbits, nil
+}
+
+// DecompressInto decompresses src into dst, which must be exactly the
+// size of the uncompressed data, and returns the number of bytes written.
+// It returns ErrShortInput if src ends before dst is full, and
+// ErrTrailingData if src has more to it than dst takes: anything after
+// the last token but 0-bit padding (or sync markers).
+func DecompressInto(dst []byte, window, lookahead uint8, src []byte, opts ...Option) (int, error) {
+	if !valid_params(window, lookahead) {
+		return 0, ErrParams
+	}
+	/* With room for exactly len(dst) bytes, the output is never moved. */
+	dict := preset_dict(get_options(opts), window, nil)
+	out, nbits, cut := decode_fast(window, lookahead, dict, src, dst[:0:len(dst)], len(dst))
+	if len(out) < len(dst) {
+		state := partial_state(window, lookahead, src, nbits)
+		return len(out), decode_error(ErrShortInput, 8*len(src), state, len(out))
+	}
+	if cut > 0 { /* backref runs past dst */
+		return len(out), decode_error(ErrTrailingData, nbits, HSDS_YIELD_BACKREF, len(out))
+	}
+	for i := nbits; i < 8*len(src); i++ {
+		if src[i/8]&(0x80>>uint(i%8)) != 0 {
+			return len(out), decode_error(ErrTrailingData, i, HSDS_TAG_BIT, len(out))
+		}
+	}
+	return len(out), nil
+}
@@ -0,0 +1,27 @@
+package heatshrink
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDecompressPrefix(t *testing.T) {
+	data := []byte("abcabcabcabc")
+	comp := Compress(8, 4, data)
+	src := append(append([]byte{}, comp...), 0xff, 0xff) /* next record */
+	out, nbits, err := DecompressPrefix(8, 4, src, len(data))
+	if err != nil || string(out) != string(data) || (nbits+7)/8 != len(comp) {
+		t.Errorf("got %q, %v bits, %v; want %q in %v bytes", out, nbits, err, data, len(comp))
+	}
+
+	/* "abc", then a backref of 9 bytes: cutting it short is an error. */
+	out, _, err = DecompressPrefix(8, 4, src, 5)
+	var de *DecodeError
+	if !errors.Is(err, ErrTrailingData) || !errors.As(err, &de) || string(out) != "abcab" {
+		t.Errorf("size 5: got %q, %v; want ErrTrailingData", out, err)
+	}
+
+	if _, _, err = DecompressPrefix(8, 4, comp[:2], len(data)); !errors.Is(err, ErrShortInput) {
+		t.Errorf("short input: got %v, want ErrShortInput", err)
+	}
+}
@@ -0,0 +1,84 @@
+package heatshrink
+
+import (
+	"io"
+)
+
+// Reader decompresses a heatshrink stream read from an underlying
+// io.Reader.
+type Reader struct {
+	hsd *decoder
+	r   io.Reader
+	buf []byte
+	err error
+	out int /* bytes returned by Read since NewReader or UnmarshalBinary */
+}
+
+// NewReader returns a Reader decompressing from r, which must have been
+// compressed with the given window and lookahead sizes (both in bits).
+// Of the options, only the window options (see WithWindowFill) apply.
+func NewReader(r io.Reader, window, lookahead uint8, opts ...Option) (*Reader, error) {
+	hsd := decoder_alloc(window, lookahead)
+	if hsd == nil {
+		return nil, ErrParams
+	}
+	decoder_preset(hsd, preset_dict(get_options(opts), window, nil))
+	return &Reader{hsd: hsd, r: r, buf: make([]byte, 4096)}, nil
+}
+
+// Read decompresses into p. If the input ends in the middle of a token,
+// rather than in the padding of its last byte, it returns ErrData in a
+// DecodeError, whose offsets count from where the Reader was created or
+// restored.
+func (z *Reader) Read(p []byte) (int, error) {
+	for z.hsd.outbuf.Len() == 0 {
+		if z.err != nil {
+			return 0, z.err
+		}
+		n, err := z.r.Read(z.buf)
+		for m := 0; m < n; {
+			_, sz := decoder_sink(z.hsd, z.buf[m:n])
+			m += int(sz)
+			decoder_poll(z.hsd)
+		}
+		z.err = err
+		if err == io.EOF && truncated(z.hsd) {
+			z.err = decode_error(ErrData, 8*z.hsd.input_total, z.hsd.state, z.out)
+		}
+	}
+	n, err := z.hsd.outbuf.Read(p)
+	z.out += n
+	return n, err
+}
+
+/* Whether the input ended in a token: the padding after the last one
+* is less than a byte, and all 0 bits, so it can't make a literal's
+* tag bit either. */
+func truncated(hsd *decoder) bool {
+	return hsd.state == HSDS_YIELD_LITERAL ||
+		hsd.state != HSDS_TAG_BIT && 8*hsd.input_total-hsd.token_bit >= 8
+}
+
+// MarshalBinary captures the decoder state, including the window and
+// any output not read yet, so that decoding can be resumed later (say,
+// in another process) with UnmarshalBinary.
+func (z *Reader) MarshalBinary() ([]byte, error) {
+	return decoder_marshal(z.hsd), nil
+}
+
+// UnmarshalBinary restores a state captured by MarshalBinary. Window and
+// lookahead sizes are taken from the state, the underlying reader is
+// kept: it should continue with the input following what was consumed
+// when the state was captured. It returns ErrState if data isn't a
+// snapshot (of this version), and ErrData if it is one, but corrupt:
+// holding values the decoder can't be left with.
+func (z *Reader) UnmarshalBinary(data []byte) error {
+	hsd, err := decoder_unmarshal(data)
+	if err != nil {
+		return err
+	}
+	z.hsd = hsd
+	z.err = nil
+	z.out = 0
+	return nil
+}
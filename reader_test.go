@@ -0,0 +1,61 @@
+package heatshrink
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"testing"
+)
+
+/* A stream cut short ends in a token, unless the cut falls just after
+* one: then the rest of the byte looks like padding. */
+func TestReaderTruncated(t *testing.T) {
+	data := test_corpus(16, 2000)
+	for _, p := range [][2]uint8{{8, 4}, {11, 10}} {
+		w, l := p[0], p[1]
+		comp := Compress(w, l, data)
+		for n := 0; n <= len(comp); n++ {
+			zr, _ := NewReader(bytes.NewReader(comp[:n]), w, l)
+			out, err := ioutil.ReadAll(zr)
+			if !bytes.Equal(out, Decompress(w, l, comp[:n])) {
+				t.Fatalf("w%vl%v cut at %v: output differs from Decompress", w, l, n)
+			}
+			if err == nil {
+				if _, nbits, _ := decode_fast(w, l, nil, comp[:n], nil, -1); 8*n-nbits >= 8 {
+					t.Errorf("w%vl%v cut at %v: %v bits left over, but no error", w, l, n, 8*n-nbits)
+				}
+				continue
+			}
+			var de *DecodeError
+			if !errors.As(err, &de) || !errors.Is(err, ErrData) || de.Offset != n ||
+				de.State == "" || de.State == "HSDS_TAG_BIT" || de.Output != len(out) {
+				t.Fatalf("w%vl%v cut at %v: %v, want ErrData at byte %v mid-token", w, l, n, err, n)
+			}
+		}
+		zr, _ := NewReader(bytes.NewReader(comp), w, l)
+		if _, err := ioutil.ReadAll(zr); err != nil {
+			t.Errorf("w%vl%v whole stream: %v", w, l, err)
+		}
+	}
+
+	/* "abc" as 27 bits of literals, cut off after 24. */
+	comp := Compress(8, 4, []byte("abcabcabcabc"))
+	zr, _ := NewReader(bytes.NewReader(comp[:3]), 8, 4)
+	out, err := ioutil.ReadAll(zr)
+	var de *DecodeError
+	if string(out) != "ab" || !errors.As(err, &de) || de.State != "HSDS_YIELD_LITERAL" {
+		t.Errorf("got %q, %v; want ab and ErrData in HSDS_YIELD_LITERAL", out, err)
+	}
+
+	/* The padding after sync markers is skipped, not taken for a token. */
+	var buf bytes.Buffer
+	zw, _ := NewWriter(&buf, 8, 4)
+	zw.Write(data[:100])
+	zw.Flush()
+	zw.Write(data[100:])
+	zw.Close()
+	zr, _ = NewReader(&buf, 8, 4)
+	if out, err := ioutil.ReadAll(zr); err != nil || !bytes.Equal(out, data) {
+		t.Errorf("flushed stream: %v", err)
+	}
+}
@@ -0,0 +1,194 @@
+package heatshrink
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+/* Encoder and decoder state snapshots. Both start with a 3 byte magic
+* and a version byte, followed by the window and lookahead sizes, the
+* state machine fields, and then the buffers, each preceded by its length
+* where that doesn't follow from the window size. Multi-byte fields are
+* big-endian. */
+const (
+	STATE_VERSION       = 1
+	decoder_state_magic = "HSd"
+	encoder_state_magic = "HSe"
+)
+
+func decoder_marshal(hsd *decoder) []byte {
+	pending := hsd.inbuf[hsd.input_index:hsd.input_size]
+	out := hsd.outbuf.Bytes()
+	b := make([]byte, 0, 32+len(pending)+len(hsd.decbuf)+len(out))
+	b = append(b, decoder_state_magic...)
+	b = append(b, STATE_VERSION, hsd.window_sz2, hsd.lookahead_sz2)
+	b = append(b, hsd.state, hsd.current_byte, hsd.bit_index)
+	b = binary.BigEndian.AppendUint16(b, hsd.head_index)
+	b = binary.BigEndian.AppendUint16(b, hsd.output_count)
+	b = binary.BigEndian.AppendUint16(b, hsd.output_index)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(pending)))
+	b = append(b, pending...)
+	b = append(b, hsd.decbuf...)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(out)))
+	b = append(b, out...)
+	return b
+}
+
+func decoder_unmarshal(data []byte) (*decoder, error) {
+	r := state_reader{data: data}
+	if string(r.next(3)) != decoder_state_magic || r.byte() != STATE_VERSION {
+		return nil, ErrState
+	}
+	hsd := decoder_alloc(r.byte(), r.byte())
+	if hsd == nil {
+		return nil, ErrState
+	}
+	hsd.state = r.byte()
+	hsd.current_byte = r.byte()
+	hsd.bit_index = r.byte()
+	hsd.head_index = r.uint16()
+	hsd.output_count = r.uint16()
+	hsd.output_index = r.uint16()
+	pending := r.next(int(r.uint16()))
+	copy(hsd.decbuf, r.next(len(hsd.decbuf)))
+	hsd.outbuf.Write(r.next(int(r.uint32())))
+	if r.bad || len(r.data) != 0 {
+		return nil, ErrState
+	}
+	if !valid_decoder_state(hsd) {
+		return nil, ErrData
+	}
+	hsd.input_size = uint16(copy(hsd.inbuf, pending))
+	return hsd, nil
+}
+
+/* Whether the decoder could be left like this, going by the state it is
+* in and what the states before it set up. Anything else could have the
+* state machine read more bits than a field has, or make a backref out
+* of range. */
+func valid_decoder_state(hsd *decoder) bool {
+	index_max := uint16(1) << hsd.window_sz2
+	count_max := uint16(1) << hsd.lookahead_sz2
+	if bits.OnesCount8(hsd.bit_index) > 1 ||
+		hsd.output_index > index_max || hsd.output_count > count_max {
+		return false
+	}
+	switch hsd.state {
+	case HSDS_TAG_BIT, HSDS_YIELD_LITERAL:
+		return true
+	case HSDS_BACKREF_INDEX_MSB:
+		return hsd.window_sz2 > 8
+	case HSDS_BACKREF_INDEX_LSB: /* the high bits read, if any */
+		return hsd.output_index&0xff == 0 && hsd.output_index < index_max
+	case HSDS_BACKREF_COUNT_MSB:
+		return hsd.lookahead_sz2 > 8 && hsd.output_index > 0
+	case HSDS_BACKREF_COUNT_LSB:
+		return hsd.output_count&0xff == 0 && hsd.output_count < count_max &&
+			hsd.output_index > 0
+	case HSDS_YIELD_BACKREF:
+		return hsd.output_count > 0 && hsd.output_index > 0
+	}
+	return false
+}
+
+func encoder_marshal(hse *encoder) []byte {
+	out := hse.outbuf.Bytes()
+	b := make([]byte, 0, 32+len(hse.buffer)+len(out))
+	b = append(b, encoder_state_magic...)
+	b = append(b, STATE_VERSION, hse.window_sz2, hse.lookahead_sz2)
+	b = append(b, hse.state, hse.current_byte, hse.bit_index)
+	b = append(b, bool_byte(hse.finishing), bool_byte(hse.flushing))
+	b = binary.BigEndian.AppendUint16(b, hse.input_size)
+	b = binary.BigEndian.AppendUint16(b, hse.match_scan_index)
+	b = binary.BigEndian.AppendUint16(b, hse.match_length)
+	b = binary.BigEndian.AppendUint16(b, hse.match_pos)
+	b = append(b, 0, 0, 0) /* was a part-pushed token, see encoder_unmarshal */
+	b = append_buffer(b, hse)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(out)))
+	b = append(b, out...)
+	return b
+}
+
+func encoder_unmarshal(data []byte) (*encoder, error) {
+	r := state_reader{data: data}
+	if string(r.next(3)) != encoder_state_magic || r.byte() != STATE_VERSION {
+		return nil, ErrState
+	}
+	hse := encoder_alloc(r.byte(), r.byte())
+	if hse == nil {
+		return nil, ErrState
+	}
+	hse.state = r.byte()
+	hse.current_byte = r.byte()
+	hse.bit_index = r.byte()
+	hse.finishing = r.byte() != 0
+	hse.flushing = r.byte() != 0
+	hse.input_size = r.uint16()
+	hse.match_scan_index = r.uint16()
+	hse.match_length = r.uint16()
+	hse.match_pos = r.uint16()
+	part_token := r.next(3)
+	buffer_write(hse, 0, r.next(len(hse.search_index)))
+	hse.outbuf.Write(r.next(int(r.uint32())))
+	if r.bad || len(r.data) != 0 {
+		return nil, ErrState
+	}
+	/* Writer only takes snapshots between calls, when the encoder has
+	* processed all it could: it waits for input (or is done), and has
+	* no token half pushed. The search index isn't part of the state,
+	* and is rebuilt when the input buffer next fills up. */
+	done := hse.state == HSES_DONE
+	if (hse.state != HSES_NOT_FULL && !done) ||
+		string(part_token) != "\x00\x00\x00" ||
+		hse.finishing != done || hse.flushing ||
+		bits.OnesCount8(hse.bit_index) != 1 ||
+		hse.match_length != 0 ||
+		hse.match_pos > 1<<hse.window_sz2 ||
+		hse.match_scan_index > hse.input_size ||
+		(!done && (hse.match_scan_index != 0 ||
+			hse.input_size >= get_input_buffer_size(hse))) ||
+		hse.input_size > get_input_buffer_size(hse) {
+		return nil, ErrData
+	}
+	return hse, nil
+}
+
+func bool_byte(b bool) byte {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+/* Sequential reader over a snapshot; running past the end sets bad and
+* yields zeros instead of failing on every field. */
+type state_reader struct {
+	data []byte
+	bad  bool
+}
+
+func (r *state_reader) next(n int) []byte {
+	if n > len(r.data) {
+		r.bad = true
+		r.data = nil
+		if n > 4 {
+			n = 4
+		}
+		return make([]byte, n)
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *state_reader) byte() byte {
+	return r.next(1)[0]
+}
+
+func (r *state_reader) uint16() uint16 {
+	return binary.BigEndian.Uint16(r.next(2))
+}
+
+func (r *state_reader) uint32() uint32 {
+	return binary.BigEndian.Uint32(r.next(4))
+}
@@ -0,0 +1,124 @@
+package heatshrink
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+	"testing/iotest"
+)
+
+/* Snapshots taken in the middle of a stream, and restored into another
+* Writer or Reader, carry on as if nothing happened. */
+func TestSnapshots(t *testing.T) {
+	data := test_corpus(5, 30000)
+	for _, p := range [][2]uint8{{4, 3}, {8, 4}, {11, 6}, {15, 14}} {
+		w, l := p[0], p[1]
+		want := Compress(w, l, data)
+
+		var buf bytes.Buffer
+		zw, _ := NewWriter(&buf, w, l)
+		zw.Write(data[:12345])
+		state, _ := zw.MarshalBinary()
+		zw2, _ := NewWriter(&buf, HEATSHRINK_MIN_WINDOW_BITS, HEATSHRINK_MIN_LOOKAHEAD_BITS)
+		if err := zw2.UnmarshalBinary(state); err != nil {
+			t.Fatal(err)
+		}
+		zw2.Write(data[12345:])
+		zw2.Close()
+		if !bytes.Equal(buf.Bytes(), want) {
+			t.Errorf("w%vl%v: resumed Writer differs from Compress", w, l)
+		}
+
+		src := bytes.NewReader(want)
+		zr, _ := NewReader(iotest.HalfReader(src), w, l)
+		head := make([]byte, 10000)
+		if _, err := io.ReadFull(zr, head); err != nil {
+			t.Fatal(err)
+		}
+		state, _ = zr.MarshalBinary()
+		zr2, _ := NewReader(src, HEATSHRINK_MIN_WINDOW_BITS, HEATSHRINK_MIN_LOOKAHEAD_BITS)
+		if err := zr2.UnmarshalBinary(state); err != nil {
+			t.Fatal(err)
+		}
+		tail, err := ioutil.ReadAll(zr2)
+		if err != nil || !bytes.Equal(append(head, tail...), data) {
+			t.Errorf("w%vl%v: resumed Reader mismatch (%v)", w, l, err)
+		}
+	}
+
+	var zr Reader
+	if err := zr.UnmarshalBinary([]byte("garbage")); err != ErrState {
+		t.Errorf("UnmarshalBinary(garbage): %v, want ErrState", err)
+	}
+}
+
+/* End of the state machine fields in snapshots, which start at 6 (see
+* state.go): before the length of the pending input for the decoder,
+* after the (former) part-pushed token for the encoder. */
+const (
+	decoder_fields_end = 15
+	encoder_fields_end = 22
+)
+
+/* Corrupt state fields are rejected, or else harmless: whatever value
+* any of them has, resuming must not bring the process down (the state
+* machines log.Fatal on some impossible states). */
+func TestCorruptSnapshots(t *testing.T) {
+	data := test_corpus(11, 3000)
+	for _, p := range [][2]uint8{{8, 4}, {12, 10}} {
+		w, l := p[0], p[1]
+		comp := Compress(w, l, data)
+
+		zr, _ := NewReader(bytes.NewReader(comp[:1001]), w, l)
+		io.ReadFull(zr, make([]byte, 10))
+		state, _ := zr.MarshalBinary()
+		for i := 6; i < decoder_fields_end; i++ {
+			for v := 0; v < 256; v++ {
+				bad := append([]byte{}, state...)
+				bad[i] = byte(v)
+				zr, _ := NewReader(bytes.NewReader(comp[1001:]), w, l)
+				if err := zr.UnmarshalBinary(bad); err == nil {
+					ioutil.ReadAll(zr)
+				} else if err != ErrData {
+					t.Fatalf("w%vl%v: byte %v = %v: %v, want ErrData", w, l, i, v, err)
+				}
+			}
+		}
+
+		var buf bytes.Buffer
+		zw, _ := NewWriter(&buf, w, l)
+		zw.Write(data[:1000])
+		state, _ = zw.MarshalBinary()
+		for i := 6; i < encoder_fields_end; i++ {
+			for v := 0; v < 256; v++ {
+				bad := append([]byte{}, state...)
+				bad[i] = byte(v)
+				zw, _ := NewWriter(ioutil.Discard, w, l)
+				if err := zw.UnmarshalBinary(bad); err == nil {
+					zw.Write(data[1000:])
+					zw.Flush()
+					zw.Close()
+				} else if err != ErrData {
+					t.Fatalf("w%vl%v: byte %v = %v: %v, want ErrData", w, l, i, v, err)
+				}
+			}
+		}
+	}
+
+	/* The ones from the report: a state that reads a field's high bits,
+	* with a field too small to have any, and a token left half pushed. */
+	zr, _ := NewReader(bytes.NewReader(nil), 8, 4)
+	state, _ := zr.MarshalBinary()
+	state[6] = HSDS_BACKREF_INDEX_MSB
+	if err := zr.UnmarshalBinary(state); !errors.Is(err, ErrData) {
+		t.Errorf("decoder in HSDS_BACKREF_INDEX_MSB with window 8: %v, want ErrData", err)
+	}
+	zw, _ := NewWriter(ioutil.Discard, 8, 4)
+	state, _ = zw.MarshalBinary()
+	state[6], state[21] = 6, 200 /* was HSES_YIELD_BR_LENGTH, 200 bits to go */
+	if err := zw.UnmarshalBinary(state); !errors.Is(err, ErrData) {
+		t.Errorf("encoder in a part-pushed token: %v, want ErrData", err)
+	}
+}
@@ -0,0 +1,111 @@
+package heatshrink
+
+import (
+	"fmt"
+	"math/bits"
+	"strings"
+)
+
+// Stats describes how an input was compressed.
+type Stats struct {
+	Window, Lookahead uint8 /* sizes used, in bits */
+	InputBytes        int
+	OutputBytes       int
+
+	Literals int
+	Backrefs int
+	/* Rejected counts searches that found a match, but one too short
+	* to use (see Option), so a literal was emitted instead. */
+	Rejected int
+
+	/* MatchLengths[n] and MatchOffsets[n] count the backrefs of length
+	* n and n bytes back, respectively. */
+	MatchLengths []int
+	MatchOffsets []int
+
+	TagBits     int
+	LiteralBits int
+	IndexBits   int
+	LengthBits  int
+	PaddingBits int /* end of stream padding and sync markers */
+}
+
+// CompressWithStats works like Compress, and also reports what the
+// compressed data is made of.
+func CompressWithStats(window, lookahead uint8, data []byte, opts ...Option) ([]byte, *Stats) {
+	o := get_options(opts)
+	flags, ok := filter_flags(o)
+	if !valid_params(window, lookahead) || !ok {
+		return nil, nil
+	}
+	hse := encoder_alloc(window, lookahead)
+	encoder_options(hse, opts)
+	encoder_preset(hse, preset_dict(o, window, nil))
+	hse.stats = &Stats{
+		Window:       window,
+		Lookahead:    lookahead,
+		MatchLengths: make([]int, (1<<lookahead)+1),
+		MatchOffsets: make([]int, (1<<window)+1),
+	}
+	out := compress(hse, filter_encode(flags, data))
+	hse.stats.InputBytes = len(data)
+	hse.stats.OutputBytes = len(out)
+	return out, hse.stats
+}
+
+func stats_add_token(hse *encoder) {
+	st := hse.stats
+	st.TagBits++
+	if hse.match_length == 0 {
+		st.Literals++
+		st.LiteralBits += 8
+	} else {
+		st.Backrefs++
+		st.IndexBits += int(hse.window_sz2)
+		st.LengthBits += int(hse.lookahead_sz2)
+		st.MatchLengths[hse.match_length]++
+		st.MatchOffsets[hse.match_pos]++
+	}
+}
+
+func stats_add_padding(hse *encoder) {
+	hse.stats.PaddingBits += bits.Len8(hse.bit_index)
+}
+
+func (st *Stats) String() string {
+	var b strings.Builder
+	ratio := 0.0
+	if st.InputBytes > 0 {
+		ratio = float64(st.OutputBytes) / float64(st.InputBytes)
+	}
+	fmt.Fprintf(&b, "window %v, lookahead %v: %v -> %v bytes (%.1f%%)\n",
+		st.Window, st.Lookahead, st.InputBytes, st.OutputBytes, 100*ratio)
+	fmt.Fprintf(&b, "literals %v, backrefs %v, rejected matches %v\n",
+		st.Literals, st.Backrefs, st.Rejected)
+	fmt.Fprintf(&b, "bits: tag %v, literal %v, index %v, length %v, padding %v\n",
+		st.TagBits, st.LiteralBits, st.IndexBits, st.LengthBits, st.PaddingBits)
+	b.WriteString("match lengths:\n")
+	write_histogram(&b, st.MatchLengths)
+	b.WriteString("match offsets:\n")
+	write_histogram(&b, st.MatchOffsets)
+	return b.String()
+}
+
+/* Print a histogram in power-of-two buckets (1, 2-3, 4-7, ...), which
+* keeps offsets of big windows readable and lines up with the bit cost
+* a variable-length code would have. */
+func write_histogram(b *strings.Builder, h []int) {
+	for lo := 1; lo < len(h); lo *= 2 {
+		hi := 2*lo - 1
+		if hi >= len(h) {
+			hi = len(h) - 1
+		}
+		n := 0
+		for _, c := range h[lo : hi+1] {
+			n += c
+		}
+		if n > 0 {
+			fmt.Fprintf(b, "  %6v-%-6v %v\n", lo, hi, n)
+		}
+	}
+}
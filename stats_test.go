@@ -0,0 +1,83 @@
+package heatshrink
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+/* "abc", then a 9 byte backref 3 bytes back: every field by hand. */
+func TestStatsFields(t *testing.T) {
+	out, st := CompressWithStats(8, 4, []byte("abcabcabcabc"))
+	lengths := make([]int, 17)
+	lengths[9] = 1
+	offsets := make([]int, 257)
+	offsets[3] = 1
+	want := Stats{
+		Window: 8, Lookahead: 4,
+		InputBytes: 12, OutputBytes: 5,
+		Literals: 3, Backrefs: 1,
+		MatchLengths: lengths, MatchOffsets: offsets,
+		TagBits: 4, LiteralBits: 24, IndexBits: 8, LengthBits: 4,
+		PaddingBits: 0,
+	}
+	if !bytes.Equal(out, Compress(8, 4, []byte("abcabcabcabc"))) {
+		t.Errorf("output differs from Compress")
+	}
+	if !reflect.DeepEqual(*st, want) {
+		t.Errorf("got\n%v\nwant\n%v", st, &want)
+	}
+}
+
+/* On a bigger input, the fields agree with each other, with the
+* disassembly and with the sizes. */
+func TestStatsConsistent(t *testing.T) {
+	data := test_corpus(15, 20000)
+	for _, p := range [][2]uint8{{8, 4}, {11, 6}} {
+		w, l := p[0], p[1]
+		out, st := CompressWithStats(w, l, data)
+		if st.InputBytes != len(data) || st.OutputBytes != len(out) {
+			t.Errorf("w%vl%v: sizes %v -> %v, want %v -> %v", w, l,
+				st.InputBytes, st.OutputBytes, len(data), len(out))
+		}
+		bits := st.TagBits + st.LiteralBits + st.IndexBits + st.LengthBits + st.PaddingBits
+		if bits != 8*len(out) || st.PaddingBits >= 8 {
+			t.Errorf("w%vl%v: %v bits (%v padding) in %v bytes", w, l, bits, st.PaddingBits, len(out))
+		}
+		if st.TagBits != st.Literals+st.Backrefs || st.LiteralBits != 8*st.Literals ||
+			st.IndexBits != int(w)*st.Backrefs || st.LengthBits != int(l)*st.Backrefs {
+			t.Errorf("w%vl%v: bit counts don't match the token counts", w, l)
+		}
+
+		lengths := make([]int, len(st.MatchLengths))
+		offsets := make([]int, len(st.MatchOffsets))
+		literals := 0
+		for _, tok := range Disassemble(w, l, out) {
+			switch tok.Kind {
+			case TokenLiteral:
+				literals++
+			case TokenBackref:
+				lengths[tok.Length]++
+				offsets[tok.Offset]++
+			}
+		}
+		if literals != st.Literals || !reflect.DeepEqual(lengths, st.MatchLengths) ||
+			!reflect.DeepEqual(offsets, st.MatchOffsets) {
+			t.Errorf("w%vl%v: tokens differ from the disassembly", w, l)
+		}
+		covered := st.Literals
+		for n, c := range st.MatchLengths {
+			covered += n * c
+		}
+		if covered != len(data) {
+			t.Errorf("w%vl%v: tokens cover %v bytes, want %v", w, l, covered, len(data))
+		}
+	}
+
+	/* Matches too short to use are counted as rejected. */
+	_, st := CompressWithStats(8, 4, []byte("abcabcabcabc"), WithMinMatch(10))
+	if st.Backrefs != 0 || st.Literals != 12 || st.Rejected == 0 {
+		t.Errorf("WithMinMatch(10): %v literals, %v backrefs, %v rejected; want all literals, some rejected",
+			st.Literals, st.Backrefs, st.Rejected)
+	}
+}
@@ -0,0 +1,287 @@
+package heatshrink
+
+import (
+	"math/bits"
+)
+
+/* Variable-length heatshrink: literals as in plain heatshrink, but
+* backrefs with variable-length (exponential Golomb) codes for the offset
+* and length rather than fixed window and lookahead size fields:
+*
+*   header      2 bytes: k_off and k_len, the orders of the codes below
+*   literal     1, then the byte (8 bits)
+*   backref     0, then code(offset - 1, k_off), then code(length - 2,
+*               k_len)
+*   code(v, k)  gamma(v >> k + 1), then the low k bits of v
+*   gamma(n)    as many 0 bits as n has bits after the leading 1, then
+*               n in binary, MSB (the leading 1) first: 1 is "1", 2 is
+*               "010", 5 is "00101"
+*
+* So an offset takes k_off + 1 bits up to 2^k_off, 2 more up to 3 *
+* 2^k_off, and so on: close backrefs take fewer bits than far ones, and
+* windows and lookaheads can be much bigger than in plain heatshrink
+* without making the usual short matches any more expensive. Window sizes
+* go up to HEATSHRINK_MAX_VARIABLE_WINDOW_BITS. The window and lookahead
+* sizes only bound what the encoder looks for (and the decoder accepts).
+* The end is padded with 0 bits, which can't make up a whole backref.
+*
+* Plain heatshrink decoders don't read this: it goes in members with
+* version MEMBER_VERSION_VARIABLE, for decoding with this package.
+*
+* As the whole input is at hand, the encoder here doesn't go through the
+* encoder state machine. It simply indexes the whole input by pairs of
+* bytes (matches are at least 2 bytes long) and finds the tokens, then
+* picks the orders that code them in the fewest bits. */
+
+const (
+	HEATSHRINK_MAX_VARIABLE_WINDOW_BITS = 19
+	VARIABLE_MAX_CHAIN                  = 1024 /* match candidates tried per position */
+	VARIABLE_HEADER_SIZE                = 2
+)
+
+func valid_variable_params(window_sz2, lookahead_sz2 uint8) bool {
+	return window_sz2 >= HEATSHRINK_MIN_WINDOW_BITS &&
+		window_sz2 <= HEATSHRINK_MAX_VARIABLE_WINDOW_BITS &&
+		lookahead_sz2 >= HEATSHRINK_MIN_LOOKAHEAD_BITS &&
+		lookahead_sz2 < window_sz2
+}
+
+/* Size in bits of code(v, k). */
+func code_bits(v int, k uint) int {
+	return 2*bits.Len(uint(v>>k+1)) - 1 + int(k)
+}
+
+type bit_writer struct {
+	out []byte
+	acc uint64 /* pending bits, in the low n bits */
+	n   uint
+}
+
+/* Write the low count (max 32) bits of value, MSB first. */
+func put_bits(bw *bit_writer, count uint, value uint32) {
+	bw.acc = bw.acc<<count | uint64(value)&(1<<count-1)
+	bw.n += count
+	for bw.n >= 8 {
+		bw.n -= 8
+		bw.out = append(bw.out, byte(bw.acc>>bw.n))
+	}
+}
+
+func put_code(bw *bit_writer, v int, k uint) {
+	n := v>>k + 1
+	nbits := uint(bits.Len(uint(n)))
+	put_bits(bw, nbits-1, 0)
+	put_bits(bw, nbits, uint32(n))
+	put_bits(bw, k, uint32(v))
+}
+
+/* The order that codes values in the fewest bits; hist[n] counts the
+* values of bit length n. */
+func best_order(hist []int, max_k uint) uint {
+	best, best_bits := uint(0), -1
+	for k := uint(0); k <= max_k; k++ {
+		total := 0
+		for n, count := range hist {
+			/* Values of bit length n take from code_bits(2^(n-1), k)
+			* to code_bits(2^n - 1, k); count them in between. */
+			v := 0
+			if n > 0 {
+				v = 3 << uint(n) >> 2
+			}
+			total += count * code_bits(v, k)
+		}
+		if best_bits < 0 || total < best_bits {
+			best, best_bits = k, total
+		}
+	}
+	return best
+}
+
+// CompressVariable compresses data into variable-length heatshrink, where
+// backrefs take fewer bits the shorter and closer they are. This allows
+// windows of up to HEATSHRINK_MAX_VARIABLE_WINDOW_BITS bits. Of the
+// options, only WithMinMatch applies: otherwise a match is used if it
+// takes fewer bits than literals. The output decodes only with
+// DecompressVariable; see CompressVariableMember for a self-describing
+// container. It returns nil if the window or lookahead size is invalid.
+//
+// To keep searching a big window affordable, at most VARIABLE_MAX_CHAIN
+// earlier positions are tried as matches for each position.
+func CompressVariable(window, lookahead uint8, data []byte, opts ...Option) []byte {
+	if !valid_variable_params(window, lookahead) {
+		return nil
+	}
+	min_match := get_options(opts).min_match
+	if min_match < 2 {
+		min_match = 2
+	}
+	window_sz := 1 << window
+	lookahead_sz := 1 << lookahead
+
+	/* head[pair] is the last position (plus 1, 0 for none) where the
+	* pair of bytes starts, prev[pos] the one before pos. */
+	head := make([]int32, 1<<16)
+	prev := make([]int32, len(data))
+	insert := func(pos int) {
+		if pos+1 < len(data) {
+			pair := int(data[pos])<<8 | int(data[pos+1])
+			prev[pos] = head[pair]
+			head[pair] = int32(pos + 1)
+		}
+	}
+
+	/* Until the orders are known, tell whether a match is worth it with
+	* some middle of the road ones. */
+	k_off, k_len := uint(window/2), uint(1)
+
+	type token struct{ offset, length int32 } /* length 0 for literals */
+	var tokens []token
+	off_hist := make([]int, window+1)
+	len_hist := make([]int, lookahead+1)
+	for pos := 0; pos < len(data); {
+		maxlen := len(data) - pos
+		if maxlen > lookahead_sz {
+			maxlen = lookahead_sz
+		}
+		match_len, match_off := 0, 0
+		if maxlen >= 2 {
+			cand := int(head[int(data[pos])<<8|int(data[pos+1])]) - 1
+			for n := 0; cand >= 0 && pos-cand <= window_sz && n < VARIABLE_MAX_CHAIN; n++ {
+				c := cand
+				cand = int(prev[cand]) - 1
+				if data[c+match_len] != data[pos+match_len] {
+					continue
+				}
+				l := 2
+				for l < maxlen && data[c+l] == data[pos+l] {
+					l++
+				}
+				if l > match_len {
+					match_len, match_off = l, pos-c
+					if l == maxlen {
+						break
+					}
+				}
+			}
+		}
+
+		if match_len >= min_match &&
+			1+code_bits(match_off-1, k_off)+code_bits(match_len-2, k_len) < 9*match_len {
+			tokens = append(tokens, token{int32(match_off), int32(match_len)})
+			off_hist[bits.Len(uint(match_off-1))]++
+			len_hist[bits.Len(uint(match_len-2))]++
+		} else {
+			match_len = 1
+			tokens = append(tokens, token{int32(data[pos]), 0})
+		}
+		for end := pos + match_len; pos < end; pos++ {
+			insert(pos)
+		}
+	}
+
+	k_off = best_order(off_hist, uint(window))
+	k_len = best_order(len_hist, uint(lookahead))
+	bw := &bit_writer{out: []byte{byte(k_off), byte(k_len)}}
+	for _, t := range tokens {
+		if t.length == 0 {
+			put_bits(bw, 9, HEATSHRINK_LITERAL_MARKER<<8|uint32(t.offset))
+		} else {
+			put_bits(bw, 1, HEATSHRINK_BACKREF_MARKER)
+			put_code(bw, int(t.offset)-1, k_off)
+			put_code(bw, int(t.length)-2, k_len)
+		}
+	}
+	if bw.n > 0 {
+		put_bits(bw, 8-bw.n, 0)
+	}
+	return bw.out
+}
+
+type bit_reader struct {
+	in  []byte
+	pos int /* bit offset */
+}
+
+/* Read count (max 32) bits, MSB first; ok is false if in runs out. */
+func get_bits_at(br *bit_reader, count int) (value uint32, ok bool) {
+	if br.pos+count > 8*len(br.in) {
+		return 0, false
+	}
+	for i := 0; i < count; i++ {
+		bit := br.in[br.pos>>3] >> (7 - uint(br.pos&7)) & 1
+		value = value<<1 | uint32(bit)
+		br.pos++
+	}
+	return value, true
+}
+
+/* Read code(v, k), for v of at most max_bits bits. Too many leading
+* zeros is an error, running out of input isn't (it's the end padding). */
+func get_code(br *bit_reader, k uint, max_bits int) (v int, ok bool, err error) {
+	zeros := 0
+	for {
+		bit, ok := get_bits_at(br, 1)
+		if !ok {
+			return 0, false, nil
+		}
+		if bit == 1 {
+			break
+		}
+		zeros++
+	}
+	if zeros+int(k) > max_bits {
+		return 0, false, ErrData
+	}
+	rest, ok := get_bits_at(br, zeros+int(k))
+	return (1<<uint(zeros)|int(rest>>k)-1)<<k | int(rest&(1<<k-1)), ok, nil
+}
+
+// DecompressVariable decompresses data made by CompressVariable with the
+// same window and lookahead sizes. It returns ErrData (in a DecodeError)
+// if data has a backref beyond them. In its State, HSDS_BACKREF_INDEX_MSB
+// and HSDS_BACKREF_COUNT_MSB stand for the offset and length codes.
+func DecompressVariable(window, lookahead uint8, data []byte) ([]byte, error) {
+	if !valid_variable_params(window, lookahead) {
+		return nil, ErrParams
+	}
+	if len(data) < VARIABLE_HEADER_SIZE ||
+		data[0] > window || data[1] > lookahead {
+		return nil, &DecodeError{Err: ErrData}
+	}
+	k_off, k_len := uint(data[0]), uint(data[1])
+	br := &bit_reader{in: data, pos: 8 * VARIABLE_HEADER_SIZE}
+	var out []byte
+	for {
+		tag, ok := get_bits_at(br, 1)
+		if !ok {
+			break
+		}
+		if tag == HEATSHRINK_LITERAL_MARKER {
+			c, ok := get_bits_at(br, 8)
+			if !ok {
+				break
+			}
+			out = append(out, byte(c))
+			continue
+		}
+		index, ok, err := get_code(br, k_off, int(window)+1)
+		if err != nil {
+			return nil, decode_error(err, br.pos, HSDS_BACKREF_INDEX_MSB, len(out))
+		} else if !ok {
+			break
+		}
+		count, ok, err := get_code(br, k_len, int(lookahead)+1)
+		if err != nil {
+			return nil, decode_error(err, br.pos, HSDS_BACKREF_COUNT_MSB, len(out))
+		} else if !ok {
+			break
+		}
+		index++
+		count += 2
+		if index > 1<<window || count > 1<<lookahead {
+			return nil, decode_error(ErrData, br.pos, HSDS_YIELD_BACKREF, len(out))
+		}
+		out = append_backref(out, 0, nil, index, count)
+	}
+	return out, nil
+}
@@ -0,0 +1,49 @@
+package heatshrink
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func TestVariable(t *testing.T) {
+	data := test_corpus(8, 40000)
+	for w := uint8(HEATSHRINK_MIN_WINDOW_BITS); w <= HEATSHRINK_MAX_VARIABLE_WINDOW_BITS; w++ {
+		for _, l := range []uint8{HEATSHRINK_MIN_LOOKAHEAD_BITS, w / 2, w - 1} {
+			if l < HEATSHRINK_MIN_LOOKAHEAD_BITS {
+				continue
+			}
+			comp := CompressVariable(w, l, data)
+			if got, err := DecompressVariable(w, l, comp); err != nil || !bytes.Equal(got, data) {
+				t.Errorf("w%vl%v: round trip mismatch (%v)", w, l, err)
+			}
+		}
+	}
+	for _, n := range []int{0, 1, 2, 3} {
+		if got, err := DecompressVariable(8, 4, CompressVariable(8, 4, data[:n])); err != nil || !bytes.Equal(got, data[:n]) {
+			t.Errorf("%v bytes: round trip mismatch (%v)", n, err)
+		}
+	}
+}
+
+/* Variable members, with windows beyond the fixed format's, mixed in with
+* the other kinds. */
+func TestVariableMembers(t *testing.T) {
+	r := rand.New(rand.NewSource(7))
+	var members, want []byte
+	for i := 0; i < 12; i++ {
+		data := test_corpus(int64(i), r.Intn(5000))
+		switch i % 3 {
+		case 0:
+			members = append(members, CompressMember(8, 4, data)...)
+		case 1:
+			members = append(members, CompressEntropyMember(10, 5, data)...)
+		case 2:
+			members = append(members, CompressVariableMember(17, 8, data)...)
+		}
+		want = append(want, data...)
+	}
+	if got, err := DecompressMembers(members); err != nil || !bytes.Equal(got, want) {
+		t.Errorf("DecompressMembers: mismatch (%v)", err)
+	}
+}
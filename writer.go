@@ -0,0 +1,121 @@
+package heatshrink
+
+import (
+	"io"
+)
+
+// Writer compresses everything written to it and passes the result on
+// to an underlying io.Writer.
+type Writer struct {
+	hse    *encoder
+	w      io.Writer
+	err    error
+	closed bool
+	opts   []Option
+}
+
+// NewWriter returns a Writer compressing to w with the given window and
+// lookahead sizes (both in bits).
+func NewWriter(w io.Writer, window, lookahead uint8, opts ...Option) (*Writer, error) {
+	hse := encoder_alloc(window, lookahead)
+	if hse == nil {
+		return nil, ErrParams
+	}
+	encoder_options(hse, opts)
+	encoder_preset(hse, preset_dict(get_options(opts), window, nil))
+	return &Writer{hse: hse, w: w, opts: opts}, nil
+}
+
+// Write compresses p. Output is held back until enough input has been
+// gathered to fill the window, see Flush.
+func (z *Writer) Write(p []byte) (int, error) {
+	if z.closed {
+		return 0, ErrClosed
+	}
+	if z.err != nil {
+		return 0, z.err
+	}
+	n := 0
+	for n < len(p) {
+		_, sz := encoder_sink(z.hse, p[n:])
+		n += int(sz)
+		encoder_poll(z.hse)
+		if err := z.drain(); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// Flush compresses all input written so far and writes it out, padded
+// to a byte boundary, without ending the stream: the window is kept, so
+// data written later can still refer back to data written before.
+//
+// If the output isn't byte-aligned already, a sync marker is emitted
+// before the padding: a backref token with its index and count fields
+// both 0 (offset 1, length 1, which the encoder never uses otherwise),
+// then 0 bits up to the byte boundary. This package's decoders skip the
+// marker and the padding. A flushed stream is therefore no longer
+// standard heatshrink data: other decoders, the C library's included,
+// copy a byte for the marker and then read the padding as tokens, so
+// all the output after a Flush is garbage. See the README for the
+// change that lets the C decoder read them.
+func (z *Writer) Flush() error {
+	if z.closed {
+		return ErrClosed
+	}
+	if z.err != nil {
+		return z.err
+	}
+	encoder_flush(z.hse)
+	return z.drain()
+}
+
+// Close compresses any remaining input and ends the stream. It does not
+// close the underlying writer.
+func (z *Writer) Close() error {
+	if z.closed {
+		return z.err
+	}
+	z.closed = true
+	if z.err != nil {
+		return z.err
+	}
+	for encoder_finish(z.hse) == HSER_FINISH_MORE {
+		encoder_poll(z.hse)
+	}
+	return z.drain()
+}
+
+// MarshalBinary captures the encoder state, including the window and
+// any input not compressed yet, so that compression can be resumed
+// later with UnmarshalBinary.
+func (z *Writer) MarshalBinary() ([]byte, error) {
+	return encoder_marshal(z.hse), nil
+}
+
+// UnmarshalBinary restores a state captured by MarshalBinary. Window and
+// lookahead sizes are taken from the state, the underlying writer and
+// options are kept. It returns ErrState if data isn't a snapshot (of
+// this version), and ErrData if it is one, but corrupt: holding values
+// the encoder can't be left with.
+func (z *Writer) UnmarshalBinary(data []byte) error {
+	hse, err := encoder_unmarshal(data)
+	if err != nil {
+		return err
+	}
+	encoder_options(hse, z.opts)
+	z.hse = hse
+	z.closed = is_finishing(hse)
+	z.err = nil
+	return nil
+}
+
+func (z *Writer) drain() error {
+	if z.hse.outbuf.Len() == 0 {
+		return nil
+	}
+	_, z.err = z.w.Write(z.hse.outbuf.Bytes())
+	z.hse.outbuf.Reset()
+	return z.err
+}
@@ -0,0 +1,48 @@
+package heatshrink
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+/* A Flush that leaves the output unaligned emits the sync marker (a
+* backref with index and count 0) and zero padding, which only this
+* package's decoders know to skip. Pin both the bits and the decoding. */
+func TestWriterFlushMarker(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, 8, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("ab"))
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	/* 1 0x61, 1 0x62, then 13 bits of marker and 1 of padding */
+	want := []byte{0xb0, 0xd8, 0x80, 0x00}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Fatalf("flushed % x, want % x", buf.Bytes(), want)
+	}
+	tokens := Disassemble(8, 4, buf.Bytes())
+	if len(tokens) != 3 || tokens[2].Kind != TokenSync || tokens[2].Bits != 14 {
+		t.Fatalf("tokens %v, want 2 literals and a 14 bit sync marker", tokens)
+	}
+
+	/* An aligned Flush emits nothing, no marker. */
+	if err := w.Flush(); err != nil || buf.Len() != len(want) {
+		t.Fatalf("second flush wrote %v bytes (%v)", buf.Len()-len(want), err)
+	}
+
+	w.Write([]byte("abab"))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := Decompress(8, 4, buf.Bytes()); string(got) != "ababab" {
+		t.Errorf("Decompress = %q, want %q", got, "ababab")
+	}
+	r, _ := NewReader(bytes.NewReader(buf.Bytes()), 8, 4)
+	if got, err := ioutil.ReadAll(r); err != nil || string(got) != "ababab" {
+		t.Errorf("Reader = %q (%v), want %q", got, err, "ababab")
+	}
+}